@@ -0,0 +1,76 @@
+// Command demo starts the Tree-sitter WebAssembly runtime and parses a file
+// with a grammar built by `tree-sitter build --wasm`:
+//
+//	go run ./cmd/demo tree-sitter-json.wasm example.json
+//
+// Without arguments it only creates a parser.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	treesitter "github.com/ShinyaIshitobi/go-tree-sitter"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	ts, err := treesitter.New(ctx)
+	if err != nil {
+		return err
+	}
+	defer ts.Close()
+
+	parser, err := ts.NewParser()
+	if err != nil {
+		return err
+	}
+	defer parser.Delete()
+	fmt.Println("Created parser")
+
+	if len(args) != 2 {
+		// No grammar has been loaded, so tree-sitter refuses to parse.
+		if _, err := parser.ParseString("hello"); err != nil {
+			fmt.Println("Parse without a language:", err)
+		}
+		return nil
+	}
+
+	grammar, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	language, err := ts.LoadLanguage(grammar)
+	if err != nil {
+		return err
+	}
+	if err := parser.SetLanguage(language); err != nil {
+		return err
+	}
+	source, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	tree, err := parser.ParseString(string(source))
+	if err != nil {
+		return err
+	}
+	defer tree.Delete()
+	root, err := tree.RootNode()
+	if err != nil {
+		return err
+	}
+	sexp, err := root.String()
+	if err != nil {
+		return err
+	}
+	fmt.Println(sexp)
+	return nil
+}
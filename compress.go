@@ -0,0 +1,58 @@
+package treesitter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic numbers that identify WebAssembly modules and compressed streams.
+// Brotli streams have none.
+var (
+	wasmMagic = []byte("\x00asm")
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressWasm reads a WebAssembly module from r, decompressing it if it
+// is compressed with gzip or zstd, as recognized by their magic numbers, or
+// otherwise with Brotli, the format of the embedded core module. A module
+// that is not compressed is read as is.
+func decompressWasm(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(wasmMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read WebAssembly module: %w", err)
+	}
+
+	var wasm []byte
+	switch {
+	case bytes.HasPrefix(magic, wasmMagic):
+		wasm, err = io.ReadAll(br)
+	case bytes.HasPrefix(magic, gzipMagic):
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(br); err == nil {
+			wasm, err = io.ReadAll(zr)
+		}
+	case bytes.HasPrefix(magic, zstdMagic):
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(br); err == nil {
+			wasm, err = io.ReadAll(zr)
+			zr.Close()
+		}
+	default:
+		wasm, err = io.ReadAll(brotli.NewReader(br))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress WebAssembly module: %w", err)
+	}
+	if !bytes.HasPrefix(wasm, wasmMagic) {
+		return nil, fmt.Errorf("failed to decompress WebAssembly module: not a WebAssembly module")
+	}
+	return wasm, nil
+}
@@ -0,0 +1,73 @@
+package treesitter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// emptyModule is the smallest valid WebAssembly module: the magic number and
+// version 1.
+var emptyModule = []byte("\x00asm\x01\x00\x00\x00")
+
+func TestDecompressWasm(t *testing.T) {
+	compress := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var b bytes.Buffer
+		w := newWriter(&b)
+		if _, err := w.Write(emptyModule); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"raw", emptyModule},
+		{"gzip", compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })},
+		{"zstd", compress(func(w io.Writer) io.WriteCloser {
+			zw, err := zstd.NewWriter(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return zw
+		})},
+		{"brotli", compress(func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wasm, err := decompressWasm(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(wasm, emptyModule) {
+				t.Errorf("decompressWasm() = %q, want %q", wasm, emptyModule)
+			}
+		})
+	}
+}
+
+func TestDecompressWasmJunk(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("not a module"), {0x1f, 0x8b, 0, 0}} {
+		if _, err := decompressWasm(bytes.NewReader(data)); err == nil {
+			t.Errorf("decompressWasm(%q) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestDecompressEmbeddedCore(t *testing.T) {
+	wasm, err := decompressWasm(bytes.NewReader(compressedWasm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(wasm, wasmMagic) {
+		t.Errorf("embedded core module does not start with %q", wasmMagic)
+	}
+}
@@ -0,0 +1,440 @@
+package treesitter
+
+import (
+	"fmt"
+)
+
+// TreeCursor walks a tree more efficiently than repeated Child and Parent
+// calls: it keeps its position as a stack inside WASM memory, so moving it
+// costs a single call.
+//
+// The core module passes cursors through its transfer buffer as four 32-bit
+// words, which TreeCursor keeps between calls. A cursor must be released with
+// Delete.
+type TreeCursor struct {
+	tree *Tree
+	// shift is the shift of the node the cursor was created at or reset to.
+	shift *rootOffset
+	state [4]uint32
+}
+
+// NewTreeCursor creates a cursor positioned at the node. The cursor cannot
+// move above it.
+func (n *Node) NewTreeCursor() (*TreeCursor, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.newTreeCursor()
+}
+
+// newTreeCursor implements NewTreeCursor for callers already holding the
+// lock.
+func (n *Node) newTreeCursor() (*TreeCursor, error) {
+	if err := n.tree.checkDeleted(); err != nil {
+		return nil, err
+	}
+	n.marshal()
+	if _, err := n.tree.ts.call("ts_tree_cursor_new_wasm", uint64(n.tree.pointer)); err != nil {
+		return nil, err
+	}
+	c := &TreeCursor{tree: n.tree, shift: n.shift}
+	c.readState()
+	return c, nil
+}
+
+// NewTreeCursor creates a cursor positioned at the root node of the tree.
+func (t *Tree) NewTreeCursor() (*TreeCursor, error) {
+	t.ts.mu.Lock()
+	defer t.ts.mu.Unlock()
+	root, err := t.rootNode()
+	if err != nil {
+		return nil, err
+	}
+	return root.newTreeCursor()
+}
+
+// readState copies the cursor out of the transfer buffer.
+func (c *TreeCursor) readState() {
+	for i := range c.state {
+		c.state[i] = c.tree.ts.readTransfer(uint32(i))
+	}
+}
+
+// marshal stores the cursor in the transfer buffer ahead of a
+// ts_tree_cursor_* call.
+func (c *TreeCursor) marshal() {
+	for i, v := range c.state {
+		c.tree.ts.writeTransfer(uint32(i), v)
+	}
+}
+
+// positions converts between the cursor's positions in bytes and in code
+// units.
+func (c *TreeCursor) positions() positions {
+	return positions{text: c.tree.offsets, shift: c.shift}
+}
+
+// call marshals the cursor and calls a ts_tree_cursor_*_wasm function.
+func (c *TreeCursor) call(name string) (uint32, error) {
+	if c.state[0] == 0 {
+		return 0, fmt.Errorf("%w: tree cursor", ErrDeleted)
+	}
+	if err := c.tree.checkDeleted(); err != nil {
+		return 0, err
+	}
+	c.marshal()
+	res, err := c.tree.ts.call(name, uint64(c.tree.pointer))
+	if err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return uint32(res[0]), nil
+}
+
+// move calls a ts_tree_cursor_goto_*_wasm function and picks up
+// the cursor's new position.
+func (c *TreeCursor) move(name string) (bool, error) {
+	moved, err := c.call(name)
+	if err != nil {
+		return false, err
+	}
+	c.readState()
+	return moved != 0, nil
+}
+
+// GotoFirstChild moves the cursor to the first child of its current node. It
+// reports false, leaving the cursor in place, if there are no children.
+func (c *TreeCursor) GotoFirstChild() (bool, error) {
+	c.tree.ts.mu.Lock()
+	defer c.tree.ts.mu.Unlock()
+	return c.move("ts_tree_cursor_goto_first_child_wasm")
+}
+
+// GotoNextSibling moves the cursor to the next sibling of its current node.
+// It reports false, leaving the cursor in place, if there is none.
+func (c *TreeCursor) GotoNextSibling() (bool, error) {
+	c.tree.ts.mu.Lock()
+	defer c.tree.ts.mu.Unlock()
+	return c.move("ts_tree_cursor_goto_next_sibling_wasm")
+}
+
+// GotoParent moves the cursor to the parent of its current node. It reports
+// false, leaving the cursor in place, if the cursor is at the node it was
+// created from.
+func (c *TreeCursor) GotoParent() (bool, error) {
+	c.tree.ts.mu.Lock()
+	defer c.tree.ts.mu.Unlock()
+	return c.move("ts_tree_cursor_goto_parent_wasm")
+}
+
+// GotoFirstChildForByte moves the cursor to the first child of its current
+// node that ends after offset, which is the child containing offset unless
+// offset falls between children. It returns the index of the child, or -1,
+// leaving the cursor in place, if every child ends at or before offset.
+//
+// The core module's ts_tree_cursor_goto_first_child_for_index_wasm only
+// reports whether the index it found is non-zero, so the children are
+// scanned here instead, one call per child.
+func (c *TreeCursor) GotoFirstChildForByte(offset uint32) (int64, error) {
+	c.tree.ts.mu.Lock()
+	defer c.tree.ts.mu.Unlock()
+	return c.gotoFirstChildFor(func() (bool, error) {
+		end, err := c.call("ts_tree_cursor_end_index_wasm")
+		return c.positions().byteOffset(end) > offset, err
+	})
+}
+
+// GotoFirstChildForPoint is like GotoFirstChildForByte, but finds the first
+// child that ends after point.
+func (c *TreeCursor) GotoFirstChildForPoint(point Point) (int64, error) {
+	c.tree.ts.mu.Lock()
+	defer c.tree.ts.mu.Unlock()
+	return c.gotoFirstChildFor(func() (bool, error) {
+		if _, err := c.call("ts_tree_cursor_end_position_wasm"); err != nil {
+			return false, err
+		}
+		end := c.positions().bytePoint(c.tree.ts.readPoint())
+		return end.Row > point.Row || end.Row == point.Row && end.Column > point.Column, nil
+	})
+}
+
+// gotoFirstChildFor moves the cursor to the first child of its current node
+// for which atGoal, called with the cursor at each child in turn, reports
+// true, and returns the child's index. It returns -1 and moves the cursor
+// back if there is none.
+func (c *TreeCursor) gotoFirstChildFor(atGoal func() (bool, error)) (int64, error) {
+	moved, err := c.move("ts_tree_cursor_goto_first_child_wasm")
+	if err != nil || !moved {
+		return -1, err
+	}
+	for index := int64(0); ; index++ {
+		ok, err := atGoal()
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return index, nil
+		}
+		if moved, err = c.move("ts_tree_cursor_goto_next_sibling_wasm"); err != nil {
+			return -1, err
+		}
+		if !moved {
+			_, err := c.move("ts_tree_cursor_goto_parent_wasm")
+			return -1, err
+		}
+	}
+}
+
+// CurrentNode returns the node the cursor is at.
+func (c *TreeCursor) CurrentNode() (*Node, error) {
+	n := &Node{}
+	if err := c.loadCurrentNode(n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// loadCurrentNode overwrites n with the node the cursor is at.
+func (c *TreeCursor) loadCurrentNode(n *Node) error {
+	c.tree.ts.mu.Lock()
+	defer c.tree.ts.mu.Unlock()
+	if _, err := c.call("ts_tree_cursor_current_node_wasm"); err != nil {
+		return err
+	}
+	c.tree.decodeNode(n, c.tree.ts.transferBuffer, c.shift)
+	return nil
+}
+
+// Reset moves the cursor to node, which may belong to a different tree of
+// the same instance, as if it had just been created there with
+// NewTreeCursor. The cursor keeps its stack, so resetting it is cheaper than
+// creating a new one.
+func (c *TreeCursor) Reset(node *Node) error {
+	ts := c.tree.ts
+	if node.tree.ts != ts {
+		return fmt.Errorf("node belongs to a different TreeSitter instance")
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if c.state[0] == 0 {
+		return fmt.Errorf("%w: tree cursor", ErrDeleted)
+	}
+	if err := node.tree.checkDeleted(); err != nil {
+		return err
+	}
+	// The node goes first in the transfer buffer, followed by the cursor.
+	node.marshal()
+	for i, v := range c.state {
+		ts.writeTransfer(nodeWords+uint32(i), v)
+	}
+	if _, err := ts.call("ts_tree_cursor_reset_wasm", uint64(node.tree.pointer)); err != nil {
+		return err
+	}
+	c.tree, c.shift = node.tree, node.shift
+	c.readState()
+	return nil
+}
+
+// ResetTo moves the cursor to the position of other, including the nodes
+// above it that other can move back up to.
+func (c *TreeCursor) ResetTo(other *TreeCursor) error {
+	ts := c.tree.ts
+	if other.tree.ts != ts {
+		return fmt.Errorf("cursor belongs to a different TreeSitter instance")
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if c.state[0] == 0 || other.state[0] == 0 {
+		return fmt.Errorf("%w: tree cursor", ErrDeleted)
+	}
+	if err := other.tree.checkDeleted(); err != nil {
+		return err
+	}
+	// The cursor goes first in the transfer buffer, followed by other.
+	c.marshal()
+	for i, v := range other.state {
+		ts.writeTransfer(uint32(len(c.state)+i), v)
+	}
+	if _, err := ts.call("ts_tree_cursor_reset_to_wasm", uint64(c.tree.pointer), uint64(other.tree.pointer)); err != nil {
+		return err
+	}
+	c.tree, c.shift = other.tree, other.shift
+	c.readState()
+	return nil
+}
+
+// Delete frees the cursor's stack. The cursor must not be used afterwards;
+// deleting it again does nothing.
+func (c *TreeCursor) Delete() error {
+	c.tree.ts.mu.Lock()
+	defer c.tree.ts.mu.Unlock()
+	if c.state[0] == 0 {
+		return nil
+	}
+	// The stack is freed even if the tree has been deleted: the module does
+	// not look at the tree, so the guard in call does not apply.
+	c.marshal()
+	if _, err := c.tree.ts.call("ts_tree_cursor_delete_wasm", uint64(c.tree.pointer)); err != nil {
+		return err
+	}
+	c.state = [4]uint32{}
+	return nil
+}
+
+// CurrentFieldName returns the name of the field connecting the current node
+// to its parent, such as "condition" for the condition of an if statement.
+// It returns "" if the node is not attached through a field.
+//
+// The name is copied out of a static string inside the language; there is
+// nothing to free.
+func (c *TreeCursor) CurrentFieldName() (string, error) {
+	c.tree.ts.mu.Lock()
+	defer c.tree.ts.mu.Unlock()
+	id, err := c.call("ts_tree_cursor_current_field_id_wasm")
+	if err != nil {
+		return "", err
+	}
+	return c.tree.language.fieldNameForID(id)
+}
+
+// Walk visits n and its descendants depth-first, calling fn with each node
+// and its depth below n, which is 0 for n itself. If fn returns false, the
+// node's descendants are skipped.
+//
+// The walk reuses a single Node for every call, so the node passed to fn is
+// only valid until fn returns; keep a copy (m := *node) to hold on to it.
+func (n *Node) Walk(fn func(n *Node, depth int) bool) error {
+	cursor, err := n.NewTreeCursor()
+	if err != nil {
+		return err
+	}
+	defer cursor.Delete()
+
+	current := &Node{}
+	depth := 0
+	for {
+		if err := cursor.loadCurrentNode(current); err != nil {
+			return err
+		}
+		if fn(current, depth) {
+			moved, err := cursor.GotoFirstChild()
+			if err != nil {
+				return err
+			}
+			if moved {
+				depth++
+				continue
+			}
+		}
+		for {
+			if depth == 0 {
+				return nil
+			}
+			moved, err := cursor.GotoNextSibling()
+			if err != nil {
+				return err
+			}
+			if moved {
+				break
+			}
+			if _, err := cursor.GotoParent(); err != nil {
+				return err
+			}
+			depth--
+		}
+	}
+}
+
+// WalkRange is like Node.Walk over the root node, but only visits the nodes
+// that overlap the bytes from start to end, such as the range of an edit
+// reported by GetChangedRanges. The range is half-open: a node overlaps if
+// it covers any byte in [start, end), or is empty and starts within it, so
+// a node that ends at start or starts at end is not visited. With start
+// equal to end, the range is the single offset start, and every node that
+// touches it is visited: those that contain it, begin or end at it, and
+// the empty nodes there. Subtrees that do not overlap are
+// skipped without being entered, so a walk over a small range stays cheap
+// in a large tree.
+//
+// As with Walk, the node passed to fn is only valid until fn returns, and
+// returning false skips the node's descendants.
+func (t *Tree) WalkRange(start, end uint32, fn func(n *Node) bool) error {
+	cursor, err := t.NewTreeCursor()
+	if err != nil {
+		return err
+	}
+	defer cursor.Delete()
+
+	current := &Node{}
+	depth := 0
+	for {
+		nodeStart, nodeEnd, err := cursor.currentByteRange()
+		if err != nil {
+			return err
+		}
+		if rangeOverlaps(nodeStart, nodeEnd, start, end) {
+			if err := cursor.loadCurrentNode(current); err != nil {
+				return err
+			}
+			if fn(current) {
+				moved, err := cursor.GotoFirstChild()
+				if err != nil {
+					return err
+				}
+				if moved {
+					depth++
+					continue
+				}
+			}
+		}
+		// Siblings are in order, so none after a node that starts past the
+		// range can overlap.
+		siblingsOverlap := nodeStart < end || nodeStart == start
+		for {
+			if depth == 0 {
+				return nil
+			}
+			if siblingsOverlap {
+				moved, err := cursor.GotoNextSibling()
+				if err != nil {
+					return err
+				}
+				if moved {
+					break
+				}
+			}
+			if _, err := cursor.GotoParent(); err != nil {
+				return err
+			}
+			depth--
+			siblingsOverlap = true
+		}
+	}
+}
+
+// rangeOverlaps reports whether the node spanning nodeStart to nodeEnd
+// overlaps the range from start to end, as WalkRange defines it.
+func rangeOverlaps(nodeStart, nodeEnd, start, end uint32) bool {
+	if start == end {
+		return nodeStart <= start && start <= nodeEnd
+	}
+	if nodeStart == nodeEnd {
+		return start <= nodeStart && nodeStart < end
+	}
+	return nodeStart < end && nodeEnd > start
+}
+
+// currentByteRange returns the start and end bytes of the cursor's current
+// node.
+func (c *TreeCursor) currentByteRange() (uint32, uint32, error) {
+	c.tree.ts.mu.Lock()
+	defer c.tree.ts.mu.Unlock()
+	start, err := c.call("ts_tree_cursor_start_index_wasm")
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := c.call("ts_tree_cursor_end_index_wasm")
+	p := c.positions()
+	return p.byteOffset(start), p.byteOffset(end), err
+}
@@ -0,0 +1,254 @@
+package treesitter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// cursorNode returns the cursor's current node, failing the test on an
+// error.
+func cursorNode(t testing.TB, c *TreeCursor) *Node {
+	t.Helper()
+	n, err := c.CurrentNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// newTestCursor returns a cursor at n, deleted when the test ends.
+func newTestCursor(t testing.TB, n *Node) *TreeCursor {
+	t.Helper()
+	c, err := n.NewTreeCursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Delete() })
+	return c
+}
+
+func TestGotoFirstChildFor(t *testing.T) {
+	p := newTestParser(t, "go")
+	source := "package main\n\nfunc a() {}\n\nfunc b() { ü := 1; x := ü }\n"
+	_, root := parseTest(t, p, source)
+	offset := uint32(strings.LastIndex(source, "ü"))
+	at := Point{Row: 4, Column: offset - uint32(strings.LastIndex(source, "\nfunc")) - 1}
+
+	for name, gotoChild := range map[string]func(*TreeCursor) (int64, error){
+		"GotoFirstChildForByte":  func(c *TreeCursor) (int64, error) { return c.GotoFirstChildForByte(offset) },
+		"GotoFirstChildForPoint": func(c *TreeCursor) (int64, error) { return c.GotoFirstChildForPoint(at) },
+	} {
+		c := newTestCursor(t, root)
+		var path []int64
+		for {
+			index, err := gotoChild(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index < 0 {
+				break
+			}
+			path = append(path, index)
+		}
+		n := cursorNode(t, c)
+		if typ, text := nodeType(t, n), nodeText(t, n, source); typ != "identifier" || text != "ü" || startByte(t, n) != offset {
+			t.Errorf("%s descended to %s %q at %d, want the last identifier ü at %d", name, typ, text, startByte(t, n), offset)
+		}
+		// The second function is the root's third child.
+		if len(path) == 0 || path[0] != 2 {
+			t.Errorf("%s path = %v, want it to start at child 2", name, path)
+		}
+	}
+
+	c := newTestCursor(t, root)
+	if index, err := c.GotoFirstChildForByte(uint32(len(source))); err != nil || index != -1 {
+		t.Errorf("GotoFirstChildForByte() past the end = %d, %v, want -1", index, err)
+	}
+	if typ := nodeType(t, cursorNode(t, c)); typ != "source_file" {
+		t.Errorf("cursor moved to %s after finding no child, want it left at source_file", typ)
+	}
+}
+
+// cursorTypes walks the subtree at the cursor and returns the types of its
+// nodes in document order, leaving the cursor where it started.
+func cursorTypes(t testing.TB, c *TreeCursor) []string {
+	t.Helper()
+	types := []string{nodeType(t, cursorNode(t, c))}
+	moved, err := c.GotoFirstChild()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for moved {
+		types = append(types, cursorTypes(t, c)...)
+		if moved, err = c.GotoNextSibling(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(types) > 1 {
+		if _, err := c.GotoParent(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return types
+}
+
+func TestTreeCursorReset(t *testing.T) {
+	p := newTestParser(t, "json")
+	_, first := parseTest(t, p, `{"a": 1}`)
+	_, second := parseTest(t, p, `[true, [null]]`)
+	c := newTestCursor(t, first)
+	if _, err := c.GotoFirstChild(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reset to the inner array of the other tree.
+	inner := child(t, child(t, second, 0), 3)
+	if err := c.Reset(inner); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(cursorTypes(t, c), " "), "array [ null ]"; got != want {
+		t.Errorf("walk after Reset = %s, want %s", got, want)
+	}
+	if moved, err := c.GotoParent(); err != nil || moved {
+		t.Errorf("GotoParent() at the node the cursor was reset to = %v, %v, want false", moved, err)
+	}
+
+	// ResetTo copies the other cursor's position and the path above it.
+	other := newTestCursor(t, first)
+	for range 2 {
+		if _, err := other.GotoFirstChild(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.ResetTo(other); err != nil {
+		t.Fatal(err)
+	}
+	if !nodeEqual(t, cursorNode(t, c), cursorNode(t, other)) {
+		t.Errorf("cursor is at %s after ResetTo, want %s", nodeType(t, cursorNode(t, c)), nodeType(t, cursorNode(t, other)))
+	}
+	if moved, err := c.GotoParent(); err != nil || !moved || nodeType(t, cursorNode(t, c)) != "object" {
+		t.Errorf("GotoParent() after ResetTo = %v, %v, want to reach the object", moved, err)
+	}
+}
+
+// BenchmarkWalk walks a whole tree with Node.Walk and with Node.Children,
+// reporting the calls to malloc each walk makes: the nodes and points travel
+// through the instance's transfer buffer, so there should be none.
+func BenchmarkWalk(b *testing.B) {
+	p := newTestParser(b, "json")
+	_, root := parseTest(b, p, largeJSON(1000))
+	ts := p.ts
+	mallocs := func() uint64 {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		return ts.stats.mallocs
+	}
+	var children func(n *Node) error
+	children = func(n *Node) error {
+		if _, err := n.EndPoint(); err != nil {
+			return err
+		}
+		nodes, err := n.Children()
+		if err != nil {
+			return err
+		}
+		for _, c := range nodes {
+			if err := children(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, bm := range []struct {
+		name string
+		walk func() error
+	}{
+		{"Walk", func() error {
+			return root.Walk(func(n *Node, depth int) bool {
+				_, err := n.EndPoint()
+				return err == nil
+			})
+		}},
+		{"Children", func() error { return children(root) }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			before := mallocs()
+			for b.Loop() {
+				if err := bm.walk(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(mallocs()-before)/float64(b.N), "mallocs/op")
+		})
+	}
+}
+
+func TestWalkRange(t *testing.T) {
+	p := newTestParser(t, "json")
+	source := `[[1, 2], [3, 4], [5]]`
+	tree, _ := parseTest(t, p, source)
+	start := uint32(strings.Index(source, "3"))
+
+	var visited []string
+	if err := tree.WalkRange(start, start+1, func(n *Node) bool {
+		visited = append(visited, nodeText(t, n, source))
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{source, source, "[3, 4]", "3"}
+	if !slices.Equal(visited, want) {
+		t.Errorf("WalkRange() visited %q, want %q", visited, want)
+	}
+
+	// Returning false skips the node's descendants.
+	visited = nil
+	if err := tree.WalkRange(start, start+1, func(n *Node) bool {
+		visited = append(visited, nodeType(t, n))
+		return nodeType(t, n) != "array"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(visited, " "); got != "document array" {
+		t.Errorf("WalkRange() stopping at the first array visited %s, want document array", got)
+	}
+}
+
+func TestWalkRangeEmpty(t *testing.T) {
+	p := newTestParser(t, "json")
+	walk := func(tree *Tree, source string, offset uint32) []string {
+		var visited []string
+		if err := tree.WalkRange(offset, offset, func(n *Node) bool {
+			visited = append(visited, fmt.Sprintf("%s %q", nodeType(t, n), nodeText(t, n, source)))
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return visited
+	}
+
+	// At the "[" of the second array, which begins there with the array.
+	source := `[[1, 2], [3, 4]]`
+	tree, _ := parseTest(t, p, source)
+	got := walk(tree, source, uint32(strings.Index(source, "[3")))
+	want := []string{
+		`document "[[1, 2], [3, 4]]"`,
+		`array "[[1, 2], [3, 4]]"`,
+		`array "[3, 4]"`,
+		`[ "["`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("WalkRange() at the second array visited %q, want %q", got, want)
+	}
+
+	// At the end, where the parser inserted an empty MISSING "]".
+	source = `[1, 2`
+	tree, _ = parseTest(t, p, source)
+	got = walk(tree, source, uint32(len(source)))
+	want = []string{`document "[1, 2"`, `array "[1, 2"`, `number "2"`, `] ""`}
+	if !slices.Equal(got, want) {
+		t.Errorf("WalkRange() at the end visited %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package treesitter
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Diagnostic is a syntax error found in a tree.
+type Diagnostic struct {
+	// Range is the part of the source the error covers. It is empty for a
+	// missing node.
+	Range Range
+	// Kind is "error" for an ERROR node, where the parser skipped text it
+	// could not make sense of, or "missing" for a MISSING node, which the
+	// parser inserted where the text lacked a token.
+	Kind string
+	// Message describes the error, such as `missing ";"`.
+	Message string
+}
+
+// Diagnostics returns the syntax errors in the tree in document order, one
+// for each ERROR and MISSING node. Errors nested inside an ERROR node are
+// reported as part of it. A tree without errors has no diagnostics.
+func (t *Tree) Diagnostics() ([]Diagnostic, error) {
+	root, err := t.RootNode()
+	if err != nil {
+		return nil, err
+	}
+	var diagnostics []Diagnostic
+	var walkErr error
+	err = root.Walk(func(n *Node, depth int) bool {
+		diagnostic, found, err := nodeDiagnostic(n)
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		if found {
+			diagnostics = append(diagnostics, diagnostic)
+			return false
+		}
+		// Only subtrees with an error in them need to be searched.
+		hasError, err := n.HasError()
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		return hasError
+	})
+	if err != nil {
+		return nil, err
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	return diagnostics, nil
+}
+
+// nodeDiagnostic returns the diagnostic for n and reports whether n is an
+// ERROR or MISSING node and so has one.
+func nodeDiagnostic(n *Node) (Diagnostic, bool, error) {
+	isError, err := n.IsError()
+	if err != nil {
+		return Diagnostic{}, false, err
+	}
+	missing, err := n.IsMissing()
+	if err != nil || !isError && !missing {
+		return Diagnostic{}, false, err
+	}
+
+	var d Diagnostic
+	if d.Range, err = n.Range(); err != nil {
+		return Diagnostic{}, false, err
+	}
+	if isError {
+		d.Kind = "error"
+		d.Message = "syntax error"
+		return d, true, nil
+	}
+	nodeType, err := n.Type()
+	if err != nil {
+		return Diagnostic{}, false, err
+	}
+	named, err := n.IsNamed()
+	if err != nil {
+		return Diagnostic{}, false, err
+	}
+	if !named {
+		nodeType = strconv.Quote(nodeType)
+	}
+	d.Kind = "missing"
+	d.Message = fmt.Sprintf("missing %s", nodeType)
+	return d, true, nil
+}
@@ -0,0 +1,35 @@
+package treesitter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	p := newTestParser(t, "go")
+	tree, _ := parseTest(t, p, "package main\nfunc f() { a(1 ; b := 2 }\nvar é = $\n")
+	diagnostics, err := tree.Diagnostics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Diagnostic{
+		{
+			Range:   Range{StartByte: 27, EndByte: 27, StartPoint: Point{Row: 1, Column: 14}, EndPoint: Point{Row: 1, Column: 14}},
+			Kind:    "missing",
+			Message: `missing ")"`,
+		},
+		{
+			Range:   Range{StartByte: 39, EndByte: 49, StartPoint: Point{Row: 2, Column: 0}, EndPoint: Point{Row: 2, Column: 10}},
+			Kind:    "error",
+			Message: "syntax error",
+		},
+	}
+	if !slices.Equal(diagnostics, want) {
+		t.Errorf("Diagnostics() = %+v, want %+v", diagnostics, want)
+	}
+
+	tree, _ = parseTest(t, p, "package main\nfunc f() { a(1); b := 2 }\n")
+	if diagnostics, err := tree.Diagnostics(); err != nil || len(diagnostics) != 0 {
+		t.Errorf("Diagnostics() of a valid tree = %+v, %v, want none", diagnostics, err)
+	}
+}
@@ -0,0 +1,33 @@
+package treesitter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintDotGraph(t *testing.T) {
+	p := newTestParser(t, "json")
+	tree, _ := parseTest(t, p, `{"a": 1]`)
+	var b bytes.Buffer
+	if err := tree.PrintDotGraph(&b); err != nil {
+		t.Fatal(err)
+	}
+	graph := b.String()
+	if !strings.HasPrefix(graph, "digraph tree {\n") || !strings.HasSuffix(graph, "}\n") {
+		t.Errorf("graph is not a digraph:\n%s", graph)
+	}
+	for _, want := range []string{
+		`node0 [label="document"]`,
+		`node1 [label="ERROR", color=red, fontcolor=red]`,
+		`[label="{", shape=plaintext]`,
+		`[label="\"", shape=plaintext]`,
+		`-> node4 [label="key"]`,
+		`[label="number"]`,
+		"node0 -> node1\n",
+	} {
+		if !strings.Contains(graph, want) {
+			t.Errorf("graph does not contain %s:\n%s", want, graph)
+		}
+	}
+}
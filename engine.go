@@ -0,0 +1,251 @@
+package treesitter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"sync"
+	"weak"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Engine holds a wazero runtime with the core module compiled into it.
+// Compiling is the expensive part of New; an Engine does it once and then
+// creates instances cheaply, each with its own memory.
+//
+// An Engine is safe for concurrent use. Closing it closes every instance it
+// created.
+type Engine struct {
+	ctx     context.Context
+	runtime wazero.Runtime
+
+	// core is the compiled core module and env the compiled env module each
+	// instance of it imports from.
+	core wazero.CompiledModule
+	env  wazero.CompiledModule
+
+	// debugLog receives the messages of the host functions, if set with
+	// WithDebugLogging. debugMu keeps lines from different instances apart.
+	debugMu  sync.Mutex
+	debugLog io.Writer
+	// maxStringLength is the longest C string an instance reads, as set
+	// with WithMaxStringLength.
+	maxStringLength uint32
+	// stdout and stderr receive the WASI output of every instance, if set
+	// with WithStdout and WithStderr.
+	stdout io.Writer
+	stderr io.Writer
+
+	// instances are the open instances the engine created, marked closed
+	// when it is. The pointers are weak, so that an instance nobody holds
+	// can still be closed by its finalizer; instancesMu guards the slice.
+	instancesMu sync.Mutex
+	instances   []weak.Pointer[TreeSitter]
+}
+
+// NewEngine creates a wazero runtime and compiles the core module embedded in
+// the package into it. The options apply to every instance it creates.
+func NewEngine(ctx context.Context, opts ...Option) (*Engine, error) {
+	wasm, err := decompressWasm(bytes.NewReader(compressedWasm))
+	if err != nil {
+		return nil, err
+	}
+	return newEngine(ctx, wasm, opts)
+}
+
+// newEngine compiles the uncompressed core module wasm into a new runtime.
+func newEngine(ctx context.Context, wasm []byte, opts []Option) (*Engine, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	r := wazero.NewRuntime(ctx)
+	e, err := compileEngine(ctx, r, wasm, o)
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	return e, nil
+}
+
+// compileEngine registers the host functions with r and compiles the core
+// module together with the env module that provides its imports, whose
+// memory is sized as o says.
+func compileEngine(ctx context.Context, r wazero.Runtime, wasm []byte, o *options) (*Engine, error) {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+	host, err := registerEnv(ctx, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register env module: %w", err)
+	}
+
+	core, err := r.CompileModule(ctx, wasm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile module: %w", err)
+	}
+	info, err := parseDylink(wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Memory layout: static data at memoryBase, followed by the stack
+	// (growing down from stackTop), followed by the malloc heap.
+	stackTop := alignUp(memoryBase+info.memorySize, 4) + stackSize
+	env := &envModule{
+		minPages:  o.minMemoryPages,
+		maxPages:  o.maxMemoryPages,
+		tableSize: tableBase + info.tableSize,
+		globals: []envGlobal{
+			{name: "__stack_pointer", mutable: true, value: stackTop},
+			{name: "__memory_base", value: memoryBase},
+			{name: "__table_base", value: tableBase},
+			{name: "__heap_base", mutable: true, value: stackTop},
+		},
+	}
+	for _, fn := range core.ImportedFunctions() {
+		moduleName, name, _ := fn.Import()
+		if moduleName != "env" {
+			continue
+		}
+		if host[name] == nil {
+			return nil, fmt.Errorf("unresolved import env.%s", name)
+		}
+		env.functions = append(env.functions, envFunction{
+			module:  hostModuleName,
+			name:    name,
+			params:  fn.ParamTypes(),
+			results: fn.ResultTypes(),
+		})
+	}
+	compiledEnv, err := r.CompileModule(ctx, env.encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile env module: %w", err)
+	}
+	return &Engine{
+		ctx:             ctx,
+		runtime:         r,
+		core:            core,
+		env:             compiledEnv,
+		debugLog:        o.debugLog,
+		maxStringLength: o.maxStringLength,
+		stdout:          o.stdout,
+		stderr:          o.stderr,
+	}, nil
+}
+
+// NewInstance starts a new instance of the core module with its own memory.
+func (e *Engine) NewInstance(ctx context.Context) (*TreeSitter, error) {
+	envMod, err := e.runtime.InstantiateModule(ctx, e.env, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate env module: %w", err)
+	}
+
+	resolverCtx := experimental.WithImportResolver(ctx, func(name string) api.Module {
+		switch name {
+		case "env", "GOT.mem":
+			return envMod
+		}
+		return nil
+	})
+	mod, err := e.runtime.InstantiateModule(resolverCtx, e.core, e.coreConfig())
+	if err != nil {
+		envMod.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate module: %w", err)
+	}
+
+	ts := &TreeSitter{
+		ctx:     ctx,
+		engine:  e,
+		runtime: e.runtime,
+		env:     envMod,
+		module:  mod,
+		memory:  envMod.ExportedMemory("memory"),
+		modules: []api.Module{envMod, mod},
+	}
+	if err := ts.start(); err != nil {
+		ts.Close()
+		return nil, err
+	}
+	runtime.SetFinalizer(ts, (*TreeSitter).Close)
+	e.instancesMu.Lock()
+	e.instances = append(e.instances, weak.Make(ts))
+	e.instancesMu.Unlock()
+	return ts, nil
+}
+
+// forget removes ts, which is being closed, from the engine's instances,
+// along with any the garbage collector has already reclaimed.
+func (e *Engine) forget(ts *TreeSitter) {
+	e.instancesMu.Lock()
+	defer e.instancesMu.Unlock()
+	e.instances = slices.DeleteFunc(e.instances, func(w weak.Pointer[TreeSitter]) bool {
+		v := w.Value()
+		return v == nil || v == ts
+	})
+}
+
+// coreConfig returns the configuration of an instance of the core module.
+// Grammars linked into the instance write through its WASI functions, so
+// their output goes where the core module's does.
+func (e *Engine) coreConfig() wazero.ModuleConfig {
+	// The real clocks let ts_parser_set_timeout_micros measure elapsed
+	// time; wazero's default clocks are fake.
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions().
+		WithSysNanotime().
+		WithSysWalltime()
+	if e.stdout != nil {
+		config = config.WithStdout(e.stdout)
+	}
+	if e.stderr != nil {
+		config = config.WithStderr(e.stderr)
+	}
+	return config
+}
+
+// start runs the core module's constructors and ts_init.
+func (ts *TreeSitter) start() error {
+	if err := ts.checkTreeSitterFunctions(); err != nil {
+		return err
+	}
+	for _, name := range []string{"__wasm_apply_data_relocs", "__wasm_call_ctors"} {
+		if ts.module.ExportedFunction(name) == nil {
+			continue
+		}
+		if _, err := ts.call(name); err != nil {
+			return err
+		}
+	}
+	res, err := ts.call("ts_init")
+	if err != nil {
+		return err
+	}
+	ts.transferBuffer = uint32(res[0])
+	return nil
+}
+
+// Close closes the runtime, and with it every instance the engine created.
+// The instances are marked closed first, so that their methods, and those
+// of the parsers, trees and other objects created from them, fail with
+// ErrClosed afterwards; a call in progress on an instance is waited for.
+func (e *Engine) Close() error {
+	e.instancesMu.Lock()
+	instances := e.instances
+	e.instances = nil
+	e.instancesMu.Unlock()
+	for _, w := range instances {
+		if ts := w.Value(); ts != nil {
+			ts.markClosed()
+		}
+	}
+	return e.runtime.Close(e.ctx)
+}
@@ -0,0 +1,174 @@
+package treesitter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// wasiWriterModule returns a module exporting write(fd), which writes msg
+// to the file descriptor fd through WASI's fd_write.
+func wasiWriterModule(msg string) []byte {
+	const i32 = 0x7f
+	b := append([]byte(nil), emptyModule...)
+	b = appendSection(b, 1, []byte{
+		2,
+		0x60, 4, i32, i32, i32, i32, 1, i32, // fd_write(fd, iovs, iovsLen, nwritten) errno
+		0x60, 1, i32, 0, // write(fd)
+	})
+	imports := appendName([]byte{1}, "wasi_snapshot_preview1")
+	imports = appendName(imports, "fd_write")
+	b = appendSection(b, 2, append(imports, externFunction, 0))
+	b = appendSection(b, 3, []byte{1, 1})
+	b = appendSection(b, 5, []byte{1, 0x00, 1})
+	b = appendSection(b, 7, append(appendName([]byte{1}, "write"), externFunction, 1))
+	body := []byte{
+		0,       // no locals
+		0x20, 0, // local.get 0
+		0x41, 0, // i32.const 0, the iovec
+		0x41, 1, // i32.const 1
+		0x41, 0x10, // i32.const 16, where the count is stored
+		0x10, 0, // call fd_write
+		0x1a, // drop
+		0x0b, // end
+	}
+	b = appendSection(b, 10, append([]byte{1, byte(len(body))}, body...))
+	// The iovec at 0 points at msg, stored from 32 on.
+	data := []byte{
+		2,
+		0, 0x41, 0, 0x0b, 8, 32, 0, 0, 0, byte(len(msg)), 0, 0, 0,
+		0, 0x41, 32, 0x0b, byte(len(msg)),
+	}
+	return appendSection(b, 11, append(data, msg...))
+}
+
+func TestWithStdoutStderr(t *testing.T) {
+	ctx := context.Background()
+	var stdout, stderr bytes.Buffer
+	e, err := NewEngine(ctx, WithStdout(&stdout), WithStderr(&stderr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	for _, tt := range []struct {
+		fd  uint64
+		msg string
+		out *bytes.Buffer
+	}{
+		{1, "to stdout\n", &stdout},
+		{2, "to stderr\n", &stderr},
+	} {
+		mod, err := e.runtime.InstantiateWithConfig(ctx, wasiWriterModule(tt.msg), e.coreConfig().WithName(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mod.ExportedFunction("write").Call(ctx, tt.fd); err != nil {
+			t.Fatal(err)
+		}
+		mod.Close(ctx)
+		if got := tt.out.String(); got != tt.msg {
+			t.Errorf("output to fd %d = %q, want %q", tt.fd, got, tt.msg)
+		}
+	}
+}
+
+func TestEngineClose(t *testing.T) {
+	ctx := context.Background()
+	e, err := NewEngine(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := e.NewInstance(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ts.NewParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLanguage(loadTestLanguage(t, ts, "json")); err != nil {
+		t.Fatal(err)
+	}
+	tree, _ := parseTest(t, p, `[1]`)
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ParseString(`[1]`); !errors.Is(err, ErrClosed) {
+		t.Errorf("ParseString() after Engine.Close: %v, want ErrClosed", err)
+	}
+	if _, err := tree.RootNode(); !errors.Is(err, ErrClosed) {
+		t.Errorf("RootNode() after Engine.Close: %v, want ErrClosed", err)
+	}
+	if err := ts.Close(); err != nil {
+		t.Errorf("TreeSitter.Close() after Engine.Close: %v", err)
+	}
+}
+
+func TestEngineNewInstance(t *testing.T) {
+	ctx := context.Background()
+	e, err := NewEngine(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	var trees []*Tree
+	for _, text := range []string{`[1]`, `{"a": true}`} {
+		ts, err := e.NewInstance(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ts.Close()
+		p, err := ts.NewParser()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.SetLanguage(loadTestLanguage(t, ts, "json")); err != nil {
+			t.Fatal(err)
+		}
+		tree, _ := parseTest(t, p, text)
+		trees = append(trees, tree)
+	}
+	if trees[0].ts == trees[1].ts || trees[0].ts.memory == trees[1].ts.memory {
+		t.Fatal("instances of one engine share their memory")
+	}
+	for i, want := range []string{"(document (array (number)))", "(document (object (pair key: (string (string_content)) value: (true))))"} {
+		if got := nodeString(t, mustRoot(t, trees[i])); got != want {
+			t.Errorf("tree %d = %s, want %s", i, got, want)
+		}
+	}
+}
+
+// BenchmarkNew measures starting an instance that compiles the core module
+// itself.
+func BenchmarkNew(b *testing.B) {
+	ctx := context.Background()
+	for b.Loop() {
+		ts, err := New(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ts.Close()
+	}
+}
+
+// BenchmarkEngineNewInstance measures starting an instance of a core module
+// compiled once by an Engine.
+func BenchmarkEngineNewInstance(b *testing.B) {
+	ctx := context.Background()
+	e, err := NewEngine(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer e.Close()
+	for b.Loop() {
+		ts, err := e.NewInstance(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ts.Close()
+	}
+}
@@ -0,0 +1,163 @@
+package treesitter
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// hostModuleName is the name of the Go host module that the generated env
+// module re-exports from.
+const hostModuleName = "tree-sitter-host"
+
+// instanceKey is the context key under which the calling *TreeSitter is
+// stored, so that host functions shared by one runtime can find the instance
+// they are serving.
+type instanceKey struct{}
+
+func instanceFrom(ctx context.Context) *TreeSitter {
+	ts, _ := ctx.Value(instanceKey{}).(*TreeSitter)
+	return ts
+}
+
+// debugf writes a line to the debug log of the engine of the instance
+// serving ctx, if it has one.
+func debugf(ctx context.Context, format string, args ...any) {
+	ts := instanceFrom(ctx)
+	if ts == nil || ts.engine == nil || ts.engine.debugLog == nil {
+		return
+	}
+	e := ts.engine
+	e.debugMu.Lock()
+	defer e.debugMu.Unlock()
+	fmt.Fprintf(e.debugLog, format+"\n", args...)
+}
+
+const i32 = api.ValueTypeI32
+
+// registerEnv instantiates the host functions imported by the core module and
+// returns their definitions by name.
+func registerEnv(ctx context.Context, r wazero.Runtime) (map[string]api.FunctionDefinition, error) {
+	compiled, err := r.NewHostModuleBuilder(hostModuleName).
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+			debugf(ctx, "tree-sitter aborted")
+			panic(fmt.Errorf("tree-sitter aborted"))
+		}), nil, nil).
+		Export("_abort_js").
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(resizeHeap),
+			[]api.ValueType{i32}, []api.ValueType{i32}).
+		Export("emscripten_resize_heap").
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(logCallback),
+			[]api.ValueType{i32, i32}, nil).
+		Export("tree_sitter_log_callback").
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(parseCallback),
+			[]api.ValueType{i32, i32, i32, i32, i32}, nil).
+		Export("tree_sitter_parse_callback").
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(progressCallback),
+			[]api.ValueType{i32, i32}, []api.ValueType{i32}).
+		Export("tree_sitter_progress_callback").
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+			stack[0] = 0
+		}), []api.ValueType{i32}, []api.ValueType{i32}).
+		Export("tree_sitter_query_progress_callback").
+		Compile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig()); err != nil {
+		return nil, err
+	}
+	return compiled.ExportedFunctions(), nil
+}
+
+// resizeHeap is emscripten_resize_heap(requestedSize), called by malloc when
+// the heap is exhausted. It grows the memory to at least requestedSize bytes
+// and returns 1, or 0 if the memory cannot grow that far. Like Emscripten's
+// own implementation, it over-allocates by up to 20% so that a growing heap
+// does not call back for every allocation.
+func resizeHeap(ctx context.Context, mod api.Module, stack []uint64) {
+	requested := uint64(uint32(stack[0]))
+	stack[0] = 0
+	memory := mod.Memory()
+	oldSize := uint64(memory.Size())
+	maxPages, _ := memory.Definition().Max()
+	maxSize := uint64(maxPages) * wasmPageSize
+	if requested <= oldSize {
+		stack[0] = 1
+		return
+	}
+	if requested > maxSize {
+		debugf(ctx, "emscripten_resize_heap: %d bytes requested, above the maximum of %d", requested, maxSize)
+		return
+	}
+	for cutDown := uint64(1); cutDown <= 4; cutDown *= 2 {
+		overGrown := min(oldSize+oldSize/(5*cutDown), requested+96<<20)
+		newSize := min(maxSize, (max(requested, overGrown)+wasmPageSize-1)/wasmPageSize*wasmPageSize)
+		if _, ok := memory.Grow(uint32((newSize - oldSize) / wasmPageSize)); ok {
+			debugf(ctx, "emscripten_resize_heap: grew memory from %d to %d bytes", oldSize, newSize)
+			stack[0] = 1
+			return
+		}
+	}
+	debugf(ctx, "emscripten_resize_heap: failed to grow memory from %d to %d bytes", oldSize, requested)
+}
+
+// parseCallback is tree_sitter_parse_callback(buffer, index, row, column,
+// lengthAddress). It copies the chunk of the current input starting at index
+// into buffer as UTF-16 code units and stores the number of code units written
+// at lengthAddress.
+func parseCallback(ctx context.Context, mod api.Module, stack []uint64) {
+	buffer := uint32(stack[0])
+	index := uint32(stack[1])
+	lengthAddress := uint32(stack[4])
+
+	var n uint32
+	if ts := instanceFrom(ctx); ts != nil && ts.input != nil {
+		n = ts.input(buffer, index)
+	}
+	mod.Memory().WriteUint32Le(lengthAddress, n)
+}
+
+// logCallback is tree_sitter_log_callback(isLexMessage, message), called for
+// each log message of a parser whose logger is enabled. It hands the message
+// to the logger of the parse in progress, with ts.mu still held by the parse;
+// see Parser.SetLogger.
+func logCallback(ctx context.Context, mod api.Module, stack []uint64) {
+	ts := instanceFrom(ctx)
+	if ts == nil || ts.logger == nil {
+		return
+	}
+	logType := LogTypeParse
+	if uint32(stack[0]) != 0 {
+		logType = LogTypeLex
+	}
+	message, err := ts.readCString(uint32(stack[1]))
+	if err != nil {
+		return
+	}
+	ts.logger(logType, message)
+}
+
+// progressCallback is tree_sitter_progress_callback(byteOffset, hasError),
+// called periodically while parsing. A nonzero result makes the core module
+// abandon the parse, which it does once the context of the parse call is
+// done or the parser's cancellation flag is set.
+func progressCallback(ctx context.Context, mod api.Module, stack []uint64) {
+	stack[0] = 0
+	if ctx.Err() != nil {
+		stack[0] = 1
+		return
+	}
+	if ts := instanceFrom(ctx); ts != nil && ts.cancellationFlag != nil && atomic.LoadUint32(ts.cancellationFlag) != 0 {
+		stack[0] = 1
+	}
+}
@@ -0,0 +1,56 @@
+package treesitter
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResizeHeap(t *testing.T) {
+	var log bytes.Buffer
+	ts := newTestInstance(t, WithDebugLogging(&log))
+	p, err := ts.NewParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLanguage(loadTestLanguage(t, ts, "json")); err != nil {
+		t.Fatal(err)
+	}
+
+	initial := ts.memory.Size()
+	text := largeJSON(1 << 17)
+	_, root := parseTest(t, p, text)
+	if end, err := root.EndByte(); err != nil || end != uint32(len(text)) {
+		t.Errorf("EndByte() = %d, %v, want %d", end, err, len(text))
+	}
+	if ts.memory.Size() <= initial {
+		t.Errorf("memory of %d bytes did not grow for a parse of %d bytes", initial, len(text))
+	}
+	if !strings.Contains(log.String(), "emscripten_resize_heap: grew memory") {
+		t.Errorf("debug log does not report the memory growing:\n%s", log.String())
+	}
+}
+
+func TestDebugLoggingDefault(t *testing.T) {
+	// Capture what the host functions would print while the heap grows.
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	p := newTestParser(t, "json")
+	initial := p.ts.memory.Size()
+	parseTest(t, p, largeJSON(1<<17))
+	if p.ts.memory.Size() <= initial {
+		t.Fatal("the parse did not grow the memory")
+	}
+	os.Stdout = stdout
+	if b, err := os.ReadFile(out.Name()); err != nil || len(b) != 0 {
+		t.Errorf("stdout without WithDebugLogging = %q, %v, want nothing", b, err)
+	}
+}
@@ -0,0 +1,47 @@
+package treesitter
+
+import "errors"
+
+// Errors returned by the wrapper, wrapped with the details of each failure.
+// Test for them with errors.Is.
+var (
+	// ErrFunctionNotFound means the core module does not export a function
+	// the wrapper needs, as with a core module from a different
+	// web-tree-sitter release.
+	ErrFunctionNotFound = errors.New("function not found")
+	// ErrUnsupported means a feature needs functions that the core module,
+	// such as a minimal build, does not export.
+	ErrUnsupported = errors.New("not supported by the core module")
+	// ErrNullPointer means the core module returned a null pointer where it
+	// should have returned an object, usually because it ran out of memory.
+	ErrNullPointer = errors.New("null pointer")
+	// ErrParseFailed means a parse produced no tree for a reason other than
+	// a timeout or cancellation, such as the parser having no language.
+	ErrParseFailed = errors.New("failed to parse")
+	// ErrParseTimeout means a parse was abandoned because it ran longer
+	// than the parser's timeout.
+	ErrParseTimeout = errors.New("parse timed out")
+	// ErrParseCancelled means a parse was abandoned because its context
+	// was done or its parser's cancellation flag was set.
+	ErrParseCancelled = errors.New("parse cancelled")
+	// ErrIncompatibleLanguageVersion means a grammar was generated for an
+	// ABI version the core module cannot parse with.
+	ErrIncompatibleLanguageVersion = errors.New("incompatible language version")
+	// ErrMemoryWrite means a value could not be written to WASM memory.
+	ErrMemoryWrite = errors.New("failed to write to memory")
+	// ErrMemoryRead means a value to be read from WASM memory lies outside
+	// it.
+	ErrMemoryRead = errors.New("failed to read from memory")
+	// ErrDeleted means an object was used after its Delete method was
+	// called.
+	ErrDeleted = errors.New("object has been deleted")
+	// ErrClosed means a TreeSitter instance or ParserPool, or an object
+	// created from one, was used after it was closed.
+	ErrClosed = errors.New("instance has been closed")
+	// ErrLanguageNotRegistered means no language is registered under a
+	// name that was looked up.
+	ErrLanguageNotRegistered = errors.New("language not registered")
+	// ErrNoLanguageForFile means no language is registered for the
+	// extension of a file to be parsed.
+	ErrNoLanguageForFile = errors.New("no language registered for file")
+)
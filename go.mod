@@ -1,3 +1,11 @@
 module github.com/ShinyaIshitobi/go-tree-sitter
 
-go 1.24.2
+go 1.25.0
+
+require (
+	github.com/andybalholm/brotli v1.2.5
+	github.com/klauspost/compress v1.20.1
+	github.com/tetratelabs/wazero v1.12.0
+)
+
+require golang.org/x/sys v0.44.0 // indirect
@@ -0,0 +1,302 @@
+package treesitter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Language is a grammar loaded into a TreeSitter instance, ready to be
+// assigned to a parser.
+type Language struct {
+	ts      *TreeSitter
+	module  api.Module
+	pointer uint32
+	version uint32
+	// wasm is the grammar the language was loaded from, for loading it
+	// into other instances, or nil for LoadLanguageFromInstance.
+	wasm []byte
+	// fieldIDs maps the grammar's field names to their ids. It is built by
+	// the first fieldIDForName and guarded by the instance's lock.
+	fieldIDs map[string]uint16
+}
+
+// The range of grammar ABI versions the embedded core module, tree-sitter
+// 0.25, can parse with.
+const (
+	MinCompatibleLanguageVersion = 13
+	LanguageVersion              = 15
+)
+
+// LoadLanguage links a compiled grammar into the instance and returns its
+// language. wasm is the side module built by `tree-sitter build --wasm`, such
+// as tree-sitter-json.wasm; its tree_sitter_<name> export provides the
+// language.
+//
+// A loaded language stays in memory until the instance is closed. It keeps
+// wasm, for Engine.ParseAll to load into other instances, so wasm must not be
+// modified afterwards.
+func (ts *TreeSitter) LoadLanguage(wasm []byte) (*Language, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	mod, err := ts.linkSideModule(wasm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load language: %w", err)
+	}
+	name, err := languageFunction(mod)
+	if err != nil {
+		return nil, err
+	}
+	return ts.newLanguage(mod, name, wasm)
+}
+
+// LoadLanguageFromInstance returns the language provided by the function
+// symbol, such as "tree_sitter_json", that a module already running in the
+// instance exports. It lets a core module built with grammars compiled in,
+// passed to NewFromWasm, provide several languages without loading any side
+// modules. Grammars loaded with LoadLanguage, which already share the
+// instance's memory, are found too. symbol may be given without its
+// "tree_sitter_" prefix.
+//
+// The function must take no arguments and return a TSLanguage pointer, and
+// the grammar must have been built against the same tree-sitter ABI as the
+// core module. A language found this way has no grammar module of its own,
+// so Engine.ParseAll cannot load it into other instances.
+func (ts *TreeSitter) LoadLanguageFromInstance(symbol string) (*Language, error) {
+	if !strings.HasPrefix(symbol, "tree_sitter_") {
+		symbol = "tree_sitter_" + symbol
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.closed {
+		return nil, fmt.Errorf("%w: cannot call %s", ErrClosed, symbol)
+	}
+	for _, mod := range ts.modules {
+		fn := mod.ExportedFunction(symbol)
+		if fn == nil {
+			continue
+		}
+		if def := fn.Definition(); len(def.ParamTypes()) != 0 || len(def.ResultTypes()) != 1 {
+			return nil, fmt.Errorf("%s is not a language function", symbol)
+		}
+		return ts.newLanguage(mod, symbol, nil)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrFunctionNotFound, symbol)
+}
+
+// newLanguage calls the language function name exported by mod and wraps
+// the language it returns. wasm is the grammar mod was linked from, or nil.
+func (ts *TreeSitter) newLanguage(mod api.Module, name string, wasm []byte) (*Language, error) {
+	res, err := mod.ExportedFunction(name).Call(ts.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", name, err)
+	}
+	pointer := uint32(res[0])
+	if pointer == 0 {
+		return nil, fmt.Errorf("%w: %s returned a null language", ErrNullPointer, name)
+	}
+	res, err = ts.call("ts_language_version", uint64(pointer))
+	if err != nil {
+		return nil, err
+	}
+	return &Language{ts: ts, module: mod, pointer: pointer, version: uint32(res[0]), wasm: wasm}, nil
+}
+
+// languageFunction finds the tree_sitter_<name> export of a grammar, as
+// opposed to the tree_sitter_<name>_external_scanner_* functions it may also
+// export.
+func languageFunction(mod api.Module) (string, error) {
+	var names []string
+	for name, def := range mod.ExportedFunctionDefinitions() {
+		if !strings.HasPrefix(name, "tree_sitter_") || strings.Contains(name, "external_scanner_") {
+			continue
+		}
+		if len(def.ParamTypes()) != 0 || len(def.ResultTypes()) != 1 {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("module exports no tree_sitter_<name> function")
+	}
+	sort.Strings(names)
+	return names[0], nil
+}
+
+// Version returns the ABI version the grammar was generated for.
+func (l *Language) Version() uint32 {
+	return l.version
+}
+
+// IsCompatible reports whether the grammar's ABI version lies between
+// MinCompatibleLanguageVersion and LanguageVersion, so that a parser can use
+// it.
+func (l *Language) IsCompatible() bool {
+	return l.version >= MinCompatibleLanguageVersion && l.version <= LanguageVersion
+}
+
+// incompatibleError explains why a parser refused the language.
+func (l *Language) incompatibleError() error {
+	return fmt.Errorf("%w: grammar has ABI version %d, want %d to %d",
+		ErrIncompatibleLanguageVersion, l.version, MinCompatibleLanguageVersion, LanguageVersion)
+}
+
+// SymbolCount returns the number of symbols in the grammar: its named and
+// anonymous node types, numbered from 0.
+func (l *Language) SymbolCount() (uint32, error) {
+	l.ts.mu.Lock()
+	defer l.ts.mu.Unlock()
+	return l.callCount("ts_language_symbol_count")
+}
+
+// SymbolName returns the name of the symbol with the given id, as reported
+// by Node.Type, or "" if there is no such symbol.
+func (l *Language) SymbolName(id uint16) (string, error) {
+	l.ts.mu.Lock()
+	defer l.ts.mu.Unlock()
+	return l.symbolName(uint32(id))
+}
+
+// SymbolForName returns the id of the symbol with the given name, looking
+// among named node types if isNamed is set and among anonymous ones, such as
+// "{", otherwise. It returns 0 if there is no such symbol.
+func (l *Language) SymbolForName(name string, isNamed bool) (uint16, error) {
+	ts := l.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	pointer, err := ts.allocateString(name)
+	if err != nil {
+		return 0, err
+	}
+	defer ts.free(pointer)
+	named := uint64(0)
+	if isNamed {
+		named = 1
+	}
+	res, err := ts.call("ts_language_symbol_for_name", uint64(l.pointer), uint64(pointer), uint64(len(name)), named)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(res[0]), nil
+}
+
+// FieldIDForName returns the id of the field with the given name, or 0 if
+// there is no such field.
+func (l *Language) FieldIDForName(name string) (uint16, error) {
+	l.ts.mu.Lock()
+	defer l.ts.mu.Unlock()
+	id, err := l.fieldIDForName(name)
+	return uint16(id), err
+}
+
+// FieldCount returns the number of fields in the grammar. Field ids start at
+// 1, so the ids in use run from 1 to FieldCount.
+func (l *Language) FieldCount() (uint32, error) {
+	l.ts.mu.Lock()
+	defer l.ts.mu.Unlock()
+	return l.callCount("ts_language_field_count")
+}
+
+// FieldNameForID returns the name of the field with the given id, or "" if
+// there is no such field.
+func (l *Language) FieldNameForID(id uint16) (string, error) {
+	l.ts.mu.Lock()
+	defer l.ts.mu.Unlock()
+	return l.fieldNameForID(uint32(id))
+}
+
+// LanguageMetadata summarizes a grammar, as returned by Language.Metadata.
+type LanguageMetadata struct {
+	// Version is the ABI version the grammar was generated for.
+	Version uint32
+	// SymbolCount is the number of symbols, as returned by SymbolCount.
+	SymbolCount uint32
+	// FieldCount is the number of fields, as returned by FieldCount.
+	FieldCount uint32
+	// StateCount is the number of states in the grammar's parse table.
+	StateCount uint32
+}
+
+// Metadata returns the grammar's version and sizes in one call.
+func (l *Language) Metadata() (LanguageMetadata, error) {
+	l.ts.mu.Lock()
+	defer l.ts.mu.Unlock()
+	m := LanguageMetadata{Version: l.version}
+	var err error
+	if m.SymbolCount, err = l.callCount("ts_language_symbol_count"); err != nil {
+		return LanguageMetadata{}, err
+	}
+	if m.FieldCount, err = l.callCount("ts_language_field_count"); err != nil {
+		return LanguageMetadata{}, err
+	}
+	if m.StateCount, err = l.callCount("ts_language_state_count"); err != nil {
+		return LanguageMetadata{}, err
+	}
+	return m, nil
+}
+
+// callCount calls a ts_language_*_count function.
+func (l *Language) callCount(name string) (uint32, error) {
+	res, err := l.ts.call(name, uint64(l.pointer))
+	if err != nil {
+		return 0, err
+	}
+	return uint32(res[0]), nil
+}
+
+// symbolName returns the name of a grammar symbol. Symbol names are static
+// strings inside the language and must not be freed.
+func (l *Language) symbolName(symbol uint32) (string, error) {
+	res, err := l.ts.call("ts_language_symbol_name", uint64(l.pointer), uint64(symbol))
+	if err != nil {
+		return "", err
+	}
+	if uint32(res[0]) == 0 {
+		return "", nil
+	}
+	return l.ts.readCString(uint32(res[0]))
+}
+
+// fieldIDForName returns the id of the named field, or 0 if there is no such
+// field. The core module does not export ts_language_field_id_for_name, so
+// the first call reads every field name into l.fieldIDs, and later ones
+// look the name up there.
+func (l *Language) fieldIDForName(name string) (uint32, error) {
+	if l.fieldIDs == nil {
+		count, err := l.callCount("ts_language_field_count")
+		if err != nil {
+			return 0, err
+		}
+		ids := make(map[string]uint16, count)
+		for id := uint32(1); id <= count; id++ {
+			fieldName, err := l.fieldNameForID(id)
+			if err != nil {
+				return 0, err
+			}
+			if _, ok := ids[fieldName]; !ok && fieldName != "" {
+				ids[fieldName] = uint16(id)
+			}
+		}
+		l.fieldIDs = ids
+	}
+	return uint32(l.fieldIDs[name]), nil
+}
+
+// fieldNameForID returns the name of a field, or "" if the id is not a
+// field. Field names are static strings inside the language and must not be
+// freed.
+func (l *Language) fieldNameForID(id uint32) (string, error) {
+	if id == 0 {
+		return "", nil
+	}
+	res, err := l.ts.call("ts_language_field_name_for_id", uint64(l.pointer), uint64(id))
+	if err != nil {
+		return "", err
+	}
+	if uint32(res[0]) == 0 {
+		return "", nil
+	}
+	return l.ts.readCString(uint32(res[0]))
+}
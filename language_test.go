@@ -0,0 +1,212 @@
+package treesitter
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestLoadLanguage(t *testing.T) {
+	ts := newTestInstance(t)
+	language := loadTestLanguage(t, ts, "json")
+	if !language.IsCompatible() {
+		t.Errorf("json grammar of version %d is not compatible", language.Version())
+	}
+	p, err := ts.NewParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLanguage(language); err != nil {
+		t.Fatal(err)
+	}
+	if p.Language() != language {
+		t.Error("Language() is not the language set")
+	}
+	tree, root := parseTest(t, p, `{"a":1}`)
+	if typ := nodeType(t, root); typ != "document" {
+		t.Errorf("root node type = %q, want %q", typ, "document")
+	}
+	if tree.Language() != language {
+		t.Error("tree's Language() is not the parser's")
+	}
+
+	if _, err := ts.LoadLanguage(emptyModule); err == nil {
+		t.Error("LoadLanguage() of a module that is not a grammar succeeded")
+	}
+	if err := p.SetLanguage(loadTestLanguage(t, newTestInstance(t), "json")); err == nil {
+		t.Error("SetLanguage() with another instance's language succeeded")
+	}
+}
+
+func TestLanguageSymbols(t *testing.T) {
+	language := loadTestLanguage(t, newTestInstance(t), "json")
+	count, err := language.SymbolCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for id := range uint16(count) {
+		name, err := language.SymbolName(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	t.Logf("%d symbols: %q", count, names)
+	for _, want := range []string{"end", "document", "object", "pair", "array", "string", "number", "{", ":"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("symbols %q do not include %q", names, want)
+		}
+	}
+	if name, err := language.SymbolName(uint16(count)); err != nil || name != "" {
+		t.Errorf("SymbolName(%d) = %q, %v, want \"\"", count, name, err)
+	}
+
+	count, err = language.FieldCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for id := uint16(1); id <= uint16(count); id++ {
+		name, err := language.FieldNameForID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, name)
+	}
+	if want := []string{"key", "value"}; !slices.Equal(fields, want) {
+		t.Errorf("fields = %q, want %q", fields, want)
+	}
+	if name, err := language.FieldNameForID(0); err != nil || name != "" {
+		t.Errorf("FieldNameForID(0) = %q, %v, want \"\"", name, err)
+	}
+}
+
+func TestSymbolForName(t *testing.T) {
+	language := loadTestLanguage(t, newTestInstance(t), "json")
+	for _, tt := range []struct {
+		name    string
+		isNamed bool
+	}{
+		{"pair", true},
+		{"string_content", true},
+		{"{", false},
+		{":", false},
+	} {
+		id, err := language.SymbolForName(tt.name, tt.isNamed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id == 0 {
+			t.Errorf("SymbolForName(%q, %v) = 0", tt.name, tt.isNamed)
+			continue
+		}
+		if name, err := language.SymbolName(id); err != nil || name != tt.name {
+			t.Errorf("SymbolName(SymbolForName(%q)) = %q, %v", tt.name, name, err)
+		}
+	}
+	for _, tt := range []struct {
+		name    string
+		isNamed bool
+	}{{"nope", true}, {"pair", false}, {"{", true}} {
+		if id, err := language.SymbolForName(tt.name, tt.isNamed); err != nil || id != 0 {
+			t.Errorf("SymbolForName(%q, %v) = %d, %v, want 0", tt.name, tt.isNamed, id, err)
+		}
+	}
+
+	for _, name := range []string{"key", "value"} {
+		id, err := language.FieldIDForName(name)
+		if err != nil || id == 0 {
+			t.Errorf("FieldIDForName(%q) = %d, %v", name, id, err)
+			continue
+		}
+		if back, err := language.FieldNameForID(id); err != nil || back != name {
+			t.Errorf("FieldNameForID(FieldIDForName(%q)) = %q, %v", name, back, err)
+		}
+	}
+	if id, err := language.FieldIDForName("nope"); err != nil || id != 0 {
+		t.Errorf("FieldIDForName(%q) = %d, %v, want 0", "nope", id, err)
+	}
+}
+
+func TestLanguageMetadata(t *testing.T) {
+	language := loadTestLanguage(t, newTestInstance(t), "json")
+	m, err := language.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SymbolCount == 0 || m.FieldCount == 0 || m.StateCount == 0 {
+		t.Errorf("Metadata() = %+v, want non-zero counts", m)
+	}
+	symbols, err := language.SymbolCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := language.FieldCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (LanguageMetadata{Version: language.Version(), SymbolCount: symbols, FieldCount: fields, StateCount: m.StateCount}); m != want {
+		t.Errorf("Metadata() = %+v, want the individual accessors' %+v", m, want)
+	}
+}
+
+func TestLoadLanguageFromInstance(t *testing.T) {
+	ts := newTestInstance(t)
+	loaded := []*Language{loadTestLanguage(t, ts, "json"), loadTestLanguage(t, ts, "go")}
+	p, err := ts.NewParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range []struct {
+		symbol, text, root string
+	}{
+		{"json", `[1]`, "document"},
+		{"tree_sitter_go", "package main\n", "source_file"},
+	} {
+		language, err := ts.LoadLanguageFromInstance(tt.symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if language.pointer != loaded[i].pointer {
+			t.Errorf("LoadLanguageFromInstance(%q) found a different language than LoadLanguage", tt.symbol)
+		}
+		if language.wasm != nil {
+			t.Errorf("LoadLanguageFromInstance(%q) has a grammar module", tt.symbol)
+		}
+		if err := p.SetLanguage(language); err != nil {
+			t.Fatal(err)
+		}
+		if _, root := parseTest(t, p, tt.text); nodeType(t, root) != tt.root {
+			t.Errorf("root node type with %s = %s, want %s", tt.symbol, nodeType(t, root), tt.root)
+		}
+	}
+
+	if _, err := ts.LoadLanguageFromInstance("python"); !errors.Is(err, ErrFunctionNotFound) {
+		t.Errorf("LoadLanguageFromInstance() of a grammar not in the instance: %v, want ErrFunctionNotFound", err)
+	}
+}
+
+func TestFieldIDForName(t *testing.T) {
+	language := loadTestLanguage(t, newTestInstance(t), "go")
+	count, err := language.FieldCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := uint16(1); id <= uint16(count); id++ {
+		name, err := language.FieldNameForID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := language.FieldIDForName(name); err != nil || got != id {
+			t.Errorf("FieldIDForName(%q) = %d, %v, want %d", name, got, err, id)
+		}
+	}
+	if id, err := language.FieldIDForName("no_such_field"); err != nil || id != 0 {
+		t.Errorf("FieldIDForName() of an unknown field = %d, %v, want 0", id, err)
+	}
+	// The names are read from the module once.
+	if len(language.fieldIDs) != int(count) {
+		t.Errorf("%d field names cached, want %d", len(language.fieldIDs), count)
+	}
+}
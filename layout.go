@@ -0,0 +1,87 @@
+package treesitter
+
+// The node accessors that hot traversals call most read the core module's
+// data structures directly where tree-sitter 0.25's memory layout for wasm32
+// allows it, instead of marshalling the node and calling an export.
+//
+// A node's id is the address of its Subtree within its parent's children
+// array, or within the tree for the root. A Subtree is 8 bytes and either
+// holds an inline leaf or points to heap data; the lowest bit of its first
+// byte, is_inline, tells them apart, since heap pointers are aligned.
+const (
+	// subtreeInlineSymbol is the offset of the symbol in an inline
+	// Subtree, after the byte of flags.
+	subtreeInlineSymbol = 1
+	// subtreeHeapSymbol is the offset of the symbol in SubtreeHeapData,
+	// after ref_count, padding, size, lookahead_bytes, error_cost and
+	// child_count.
+	subtreeHeapSymbol = 40
+	// languagePublicSymbolMap is the offset in TSLanguage of
+	// public_symbol_map, after nine counts, max_alias_sequence_length and
+	// nine pointers.
+	languagePublicSymbolMap = 76
+)
+
+// symbolError is ts_builtin_sym_error, the symbol of ERROR nodes, which has
+// no entry in the public symbol map.
+const symbolError = 0xffff
+
+// subtreeSymbol reads the symbol of the grammar rule the node was parsed as,
+// as ts_node_grammar_symbol returns it. It reports false for the null node,
+// for a node of a deleted tree, whose memory has been freed, or if the
+// memory cannot be read.
+func (n *Node) subtreeSymbol() (uint16, bool) {
+	if n.IsNull() || n.tree.pointer == 0 {
+		return 0, false
+	}
+	memory := n.tree.ts.memory
+	flags, ok := memory.ReadByte(n.id)
+	if !ok {
+		return 0, false
+	}
+	if flags&1 != 0 {
+		symbol, ok := memory.ReadByte(n.id + subtreeInlineSymbol)
+		return uint16(symbol), ok
+	}
+	data, ok := memory.ReadUint32Le(n.id)
+	if !ok {
+		return 0, false
+	}
+	return memory.ReadUint16Le(data + subtreeHeapSymbol)
+}
+
+// publicSymbol maps symbol to the symbol the language exposes for it, as
+// ts_language_public_symbol does, merging symbols that share a name and
+// type. It reports false if the memory cannot be read.
+func (l *Language) publicSymbol(symbol uint16) (uint16, bool) {
+	if symbol == symbolError {
+		return symbol, true
+	}
+	memory := l.ts.memory
+	symbolMap, ok := memory.ReadUint32Le(l.pointer + languagePublicSymbolMap)
+	if !ok {
+		return 0, false
+	}
+	return memory.ReadUint16Le(symbolMap + 2*uint32(symbol))
+}
+
+// symbol returns the node's symbol as ts_node_symbol does: its alias if it
+// has one, otherwise its grammar symbol, mapped to the public symbol. It
+// falls back to calling ts_node_symbol_wasm if the memory cannot be read.
+func (n *Node) symbol() (uint16, error) {
+	if err := n.tree.checkDeleted(); err != nil {
+		return 0, err
+	}
+	symbol := uint16(n.alias)
+	ok := !n.IsNull()
+	if ok && symbol == 0 {
+		symbol, ok = n.subtreeSymbol()
+	}
+	if ok {
+		if symbol, ok = n.tree.language.publicSymbol(symbol); ok {
+			return symbol, nil
+		}
+	}
+	res, err := n.callUint32("ts_node_symbol_wasm")
+	return uint16(res), err
+}
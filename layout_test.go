@@ -0,0 +1,87 @@
+package treesitter
+
+import "testing"
+
+// exportUint32 calls the ts_node_*_wasm function name on n, as the accessors
+// did before they decoded the node's memory.
+func exportUint32(t testing.TB, n *Node, name string) uint32 {
+	t.Helper()
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	res, err := n.callUint32(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestDecodedFields(t *testing.T) {
+	p := newTestParser(t, "go")
+	// Method names are field_identifier, an alias of identifier; the
+	// missing "}" is a MISSING node and "@" an ERROR.
+	source := "package main\n\n// é😀\nfunc (p T) name() { x.y(\"ü\", @) "
+	_, root := parseTest(t, p, source)
+	if err := root.Walk(func(n *Node, depth int) bool {
+		typ := nodeType(t, n)
+		if start := exportUint32(t, n, "ts_node_start_index_wasm"); startByte(t, n) != n.positions().byteOffset(start) {
+			t.Errorf("StartByte() of %s = %d, want %d", typ, startByte(t, n), n.positions().byteOffset(start))
+		}
+		symbol, err := n.Symbol()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := exportUint32(t, n, "ts_node_symbol_wasm"); symbol != uint16(want) {
+			t.Errorf("Symbol() of %s = %d, want %d", typ, symbol, want)
+		}
+		grammar, err := n.GrammarSymbol()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := exportUint32(t, n, "ts_node_grammar_symbol_wasm"); grammar != uint16(want) {
+			t.Errorf("GrammarSymbol() of %s = %d, want %d", typ, grammar, want)
+		}
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkStartByte compares reading a node's start from its decoded
+// fields with calling ts_node_start_index_wasm.
+func BenchmarkStartByte(b *testing.B) {
+	p := newTestParser(b, "json")
+	_, root := parseTest(b, p, `{"a": [1, 2]}`)
+	n := child(b, child(b, child(b, root, 0), 1), 2)
+	b.Run("decoded", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := n.StartByte(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("export", func(b *testing.B) {
+		for b.Loop() {
+			exportUint32(b, n, "ts_node_start_index_wasm")
+		}
+	})
+}
+
+// BenchmarkSymbol compares reading a node's symbol from the tree's memory
+// with calling ts_node_symbol_wasm.
+func BenchmarkSymbol(b *testing.B) {
+	p := newTestParser(b, "json")
+	_, root := parseTest(b, p, `{"a": [1, 2]}`)
+	n := child(b, child(b, child(b, root, 0), 1), 2)
+	b.Run("decoded", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := n.Symbol(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("export", func(b *testing.B) {
+		for b.Loop() {
+			exportUint32(b, n, "ts_node_symbol_wasm")
+		}
+	})
+}
@@ -0,0 +1,698 @@
+package treesitter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// web-tree-sitter is built as an Emscripten dynamic-linking module: it does
+// not define its own memory, table or stack, but imports them from "env" (and
+// "GOT.mem") and expects the loader to choose where its static data lives.
+// wazero host modules can only export functions, so the loader below encodes a
+// tiny WebAssembly "env" module on the fly that owns those objects and simply
+// re-exports the Go host functions.
+
+const (
+	// memoryBase is where the core module's static data is placed. Emscripten
+	// uses the same value (GLOBAL_BASE) so that low addresses stay unused.
+	memoryBase = 1024
+	// tableBase is the first table slot given to the core module; slot 0 is
+	// reserved so that a NULL function pointer never resolves.
+	tableBase = 1
+	// stackSize is the size of the shadow stack placed after static data.
+	stackSize = 1 << 20
+	// wasmPageSize is the size of a WebAssembly memory page.
+	wasmPageSize = 1 << 16
+	// minMemoryPages and maxMemoryPages match the limits the core module
+	// declares for its imported memory.
+	minMemoryPages = 512
+	maxMemoryPages = 32768
+)
+
+// dylinkInfo is the WASM_DYLINK_MEM_INFO subsection of a "dylink.0" custom
+// section.
+type dylinkInfo struct {
+	memorySize  uint32
+	memoryAlign uint32
+	tableSize   uint32
+	tableAlign  uint32
+}
+
+// forEachSection calls fn with the id and payload of each section of a
+// WebAssembly module.
+func forEachSection(wasm []byte, fn func(id byte, payload []byte) error) error {
+	if len(wasm) < 8 || !bytes.Equal(wasm[:4], []byte("\x00asm")) {
+		return errors.New("not a WebAssembly module")
+	}
+	r := bytes.NewReader(wasm[8:])
+	for r.Len() > 0 {
+		id, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if size > uint64(r.Len()) {
+			return errors.New("truncated WebAssembly section")
+		}
+		payload := make([]byte, size)
+		if _, err := r.Read(payload); err != nil {
+			return err
+		}
+		if err := fn(id, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readName reads a length-prefixed name.
+func readName(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(r.Len()) {
+		return "", errors.New("truncated name")
+	}
+	name := make([]byte, n)
+	if _, err := r.Read(name); err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+// parseDylink reads the dylink.0 section of a relocatable module.
+func parseDylink(wasm []byte) (dylinkInfo, error) {
+	var info dylinkInfo
+	found := false
+	err := forEachSection(wasm, func(id byte, section []byte) error {
+		if id != 0 || found {
+			return nil
+		}
+		sr := bytes.NewReader(section)
+		name, err := readName(sr)
+		if err != nil {
+			return err
+		}
+		if name != "dylink.0" {
+			return nil
+		}
+		found = true
+		for sr.Len() > 0 {
+			kind, err := sr.ReadByte()
+			if err != nil {
+				return err
+			}
+			subLen, err := binary.ReadUvarint(sr)
+			if err != nil {
+				return err
+			}
+			sub := make([]byte, subLen)
+			if _, err := sr.Read(sub); err != nil {
+				return err
+			}
+			if kind != 1 { // WASM_DYLINK_MEM_INFO
+				continue
+			}
+			mr := bytes.NewReader(sub)
+			fields := []*uint32{&info.memorySize, &info.memoryAlign, &info.tableSize, &info.tableAlign}
+			for _, f := range fields {
+				v, err := binary.ReadUvarint(mr)
+				if err != nil {
+					return fmt.Errorf("malformed dylink.0 memory info: %w", err)
+				}
+				*f = uint32(v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return info, err
+	}
+	if !found {
+		return info, errors.New("module has no dylink.0 section")
+	}
+	return info, nil
+}
+
+// External kinds of WebAssembly imports and exports.
+const (
+	externFunction = 0x00
+	externTable    = 0x01
+	externMemory   = 0x02
+	externGlobal   = 0x03
+)
+
+// wasmImport is an entry of a module's import section. mutable is only
+// meaningful for globals.
+type wasmImport struct {
+	module  string
+	name    string
+	kind    byte
+	mutable bool
+}
+
+// parseImports lists the imports of a module. wazero reports imported
+// functions and memories, but not globals, which side modules use for their
+// GOT entries.
+func parseImports(wasm []byte) ([]wasmImport, error) {
+	var imports []wasmImport
+	err := forEachSection(wasm, func(id byte, section []byte) error {
+		if id != 2 {
+			return nil
+		}
+		r := bytes.NewReader(section)
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		for range count {
+			var imp wasmImport
+			if imp.module, err = readName(r); err != nil {
+				return err
+			}
+			if imp.name, err = readName(r); err != nil {
+				return err
+			}
+			if imp.kind, err = r.ReadByte(); err != nil {
+				return err
+			}
+			switch imp.kind {
+			case externFunction:
+				_, err = binary.ReadUvarint(r)
+			case externTable:
+				if _, err = r.ReadByte(); err == nil {
+					err = skipLimits(r)
+				}
+			case externMemory:
+				err = skipLimits(r)
+			case externGlobal:
+				var mut byte
+				if _, err = r.ReadByte(); err == nil {
+					mut, err = r.ReadByte()
+					imp.mutable = mut == 1
+				}
+			default:
+				err = fmt.Errorf("unknown import kind %d", imp.kind)
+			}
+			if err != nil {
+				return fmt.Errorf("malformed import %s.%s: %w", imp.module, imp.name, err)
+			}
+			imports = append(imports, imp)
+		}
+		return nil
+	})
+	return imports, err
+}
+
+// skipLimits skips the limits of a table or memory type.
+func skipLimits(r *bytes.Reader) error {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if _, err := binary.ReadUvarint(r); err != nil {
+		return err
+	}
+	if flags&1 != 0 {
+		_, err = binary.ReadUvarint(r)
+	}
+	return err
+}
+
+// alignUp rounds v up to a multiple of 1<<log2.
+func alignUp(v, log2 uint32) uint32 {
+	mask := uint32(1)<<log2 - 1
+	return (v + mask) &^ mask
+}
+
+// envGlobal is an i32 global exported by an env module. If module is set,
+// the global is imported from there and re-exported; otherwise it is defined
+// with the given value.
+type envGlobal struct {
+	module  string
+	name    string
+	mutable bool
+	value   uint32
+}
+
+// envFunction is a function imported by an env module and re-exported under
+// the same name.
+type envFunction struct {
+	module  string
+	name    string
+	params  []api.ValueType
+	results []api.ValueType
+}
+
+// tableGrowName is the function exported by the core env module to grow the
+// shared table, which wazero does not allow from Go. It takes the number of
+// slots to add and returns the previous size, or -1 on failure.
+const tableGrowName = "__table_grow"
+
+// envModule describes a generated module that satisfies the imports of a
+// dynamically linked module.
+//
+// The env module of the core module defines the memory and the table. The
+// env modules of side modules have importFrom set: they import both from
+// that module and re-export them alongside their own globals.
+type envModule struct {
+	importFrom string
+	minPages   uint32
+	maxPages   uint32
+	tableSize  uint32
+	globals    []envGlobal
+	functions  []envFunction
+}
+
+// encode renders the module in the WebAssembly binary format.
+func (m *envModule) encode() []byte {
+	var types typeSection
+
+	// Imported globals come first in the global index space.
+	globalIndex := make([]uint32, len(m.globals))
+	var importedGlobals, definedGlobals []envGlobal
+	for i, g := range m.globals {
+		if g.module != "" {
+			globalIndex[i] = uint32(len(importedGlobals))
+			importedGlobals = append(importedGlobals, g)
+		}
+	}
+	for i, g := range m.globals {
+		if g.module == "" {
+			globalIndex[i] = uint32(len(importedGlobals) + len(definedGlobals))
+			definedGlobals = append(definedGlobals, g)
+		}
+	}
+
+	var imports []byte
+	importCount := len(m.functions) + len(importedGlobals)
+	if m.importFrom != "" {
+		importCount += 2
+	}
+	imports = binary.AppendUvarint(imports, uint64(importCount))
+	for _, f := range m.functions {
+		imports = appendName(imports, f.module)
+		imports = appendName(imports, f.name)
+		imports = append(imports, externFunction)
+		imports = binary.AppendUvarint(imports, uint64(types.index(f.params, f.results)))
+	}
+	if m.importFrom != "" {
+		imports = appendName(imports, m.importFrom)
+		imports = appendName(imports, "__indirect_function_table")
+		imports = append(imports, externTable, 0x70, 0x00, 0x00)
+		imports = appendName(imports, m.importFrom)
+		imports = appendName(imports, "memory")
+		imports = append(imports, externMemory, 0x00, 0x00)
+	}
+	for _, g := range importedGlobals {
+		imports = appendName(imports, g.module)
+		imports = appendName(imports, g.name)
+		imports = append(imports, externGlobal, api.ValueTypeI32, mutability(g.mutable))
+	}
+
+	var functions, code []byte
+	if m.importFrom == "" {
+		// table.grow needs an initial value for the new slots.
+		functions = append(functions, 1)
+		functions = binary.AppendUvarint(functions, uint64(types.index([]api.ValueType{i32}, []api.ValueType{i32})))
+		body := []byte{
+			0x00,       // no locals
+			0xd0, 0x70, // ref.null func
+			0x20, 0x00, // local.get 0
+			0xfc, 0x0f, 0x00, // table.grow 0
+			0x0b, // end
+		}
+		code = append(code, 1)
+		code = binary.AppendUvarint(code, uint64(len(body)))
+		code = append(code, body...)
+	}
+
+	var table []byte
+	table = append(table, 1, 0x70, 0x00)
+	table = binary.AppendUvarint(table, uint64(m.tableSize))
+
+	var memory []byte
+	memory = append(memory, 1, 0x01)
+	memory = binary.AppendUvarint(memory, uint64(m.minPages))
+	memory = binary.AppendUvarint(memory, uint64(m.maxPages))
+
+	var globals []byte
+	globals = binary.AppendUvarint(globals, uint64(len(definedGlobals)))
+	for _, g := range definedGlobals {
+		globals = append(globals, api.ValueTypeI32, mutability(g.mutable))
+		globals = append(globals, 0x41) // i32.const
+		globals = appendSleb(globals, int32(g.value))
+		globals = append(globals, 0x0b) // end
+	}
+
+	var exports []byte
+	exportCount := len(m.functions) + len(m.globals) + 2
+	if m.importFrom == "" {
+		exportCount++
+	}
+	exports = binary.AppendUvarint(exports, uint64(exportCount))
+	for i, f := range m.functions {
+		exports = appendName(exports, f.name)
+		exports = append(exports, externFunction)
+		exports = binary.AppendUvarint(exports, uint64(i))
+	}
+	if m.importFrom == "" {
+		exports = appendName(exports, tableGrowName)
+		exports = append(exports, externFunction)
+		exports = binary.AppendUvarint(exports, uint64(len(m.functions)))
+	}
+	exports = appendName(exports, "memory")
+	exports = append(exports, externMemory, 0x00)
+	exports = appendName(exports, "__indirect_function_table")
+	exports = append(exports, externTable, 0x00)
+	for i, g := range m.globals {
+		exports = appendName(exports, g.name)
+		exports = append(exports, externGlobal)
+		exports = binary.AppendUvarint(exports, uint64(globalIndex[i]))
+	}
+
+	out := []byte("\x00asm\x01\x00\x00\x00")
+	out = appendSection(out, 1, types.encode())
+	out = appendSection(out, 2, imports)
+	if m.importFrom == "" {
+		out = appendSection(out, 3, functions)
+		out = appendSection(out, 4, table)
+		out = appendSection(out, 5, memory)
+	}
+	out = appendSection(out, 6, globals)
+	out = appendSection(out, 7, exports)
+	if m.importFrom == "" {
+		out = appendSection(out, 10, code)
+	}
+	return out
+}
+
+// encodeTableInit renders a module whose only purpose is to store functions
+// in the table exported by tableFrom, starting at offset. Side modules refer
+// to the functions behind their GOT.func entries by table slot, and only an
+// element segment can put a function from another module into a table.
+func encodeTableInit(tableFrom string, offset uint32, functions []envFunction) []byte {
+	var types typeSection
+	var imports []byte
+	imports = binary.AppendUvarint(imports, uint64(len(functions)+1))
+	for _, f := range functions {
+		imports = appendName(imports, f.module)
+		imports = appendName(imports, f.name)
+		imports = append(imports, externFunction)
+		imports = binary.AppendUvarint(imports, uint64(types.index(f.params, f.results)))
+	}
+	imports = appendName(imports, tableFrom)
+	imports = appendName(imports, "__indirect_function_table")
+	imports = append(imports, externTable, 0x70, 0x00, 0x00)
+
+	var elements []byte
+	elements = append(elements, 1, 0x00) // one active segment for table 0
+	elements = append(elements, 0x41)    // i32.const
+	elements = appendSleb(elements, int32(offset))
+	elements = append(elements, 0x0b) // end
+	elements = binary.AppendUvarint(elements, uint64(len(functions)))
+	for i := range functions {
+		elements = binary.AppendUvarint(elements, uint64(i))
+	}
+
+	out := []byte("\x00asm\x01\x00\x00\x00")
+	out = appendSection(out, 1, types.encode())
+	out = appendSection(out, 2, imports)
+	out = appendSection(out, 9, elements)
+	return out
+}
+
+// typeSection collects the distinct function signatures of a module.
+type typeSection [][]byte
+
+// index returns the index of the signature, adding it if needed.
+func (s *typeSection) index(params, results []api.ValueType) uint32 {
+	var sig []byte
+	sig = append(sig, 0x60)
+	sig = appendValueTypes(sig, params)
+	sig = appendValueTypes(sig, results)
+	for i, t := range *s {
+		if bytes.Equal(t, sig) {
+			return uint32(i)
+		}
+	}
+	*s = append(*s, sig)
+	return uint32(len(*s) - 1)
+}
+
+// encode renders the payload of the type section.
+func (s typeSection) encode() []byte {
+	var b []byte
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	for _, t := range s {
+		b = append(b, t...)
+	}
+	return b
+}
+
+func mutability(mutable bool) byte {
+	if mutable {
+		return 0x01
+	}
+	return 0x00
+}
+
+func appendValueTypes(b []byte, types []api.ValueType) []byte {
+	b = binary.AppendUvarint(b, uint64(len(types)))
+	return append(b, types...)
+}
+
+// appendSleb appends v in signed LEB128, the encoding of i32.const operands.
+func appendSleb(b []byte, v int32) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(name)))
+	return append(b, name...)
+}
+
+func appendSection(b []byte, id byte, payload []byte) []byte {
+	b = append(b, id)
+	b = binary.AppendUvarint(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+// Module names used by the generated modules that link a side module. They
+// are resolved per instantiation, so they only need to be distinct from one
+// another.
+const (
+	coreEnvName    = "env"
+	coreModuleName = "tree-sitter"
+	sideModuleName = "side"
+)
+
+// gotEntry is a GOT.mem or GOT.func import of a side module: a global that
+// must hold the address, or table slot, of a symbol once it is known.
+type gotEntry struct {
+	name  string
+	value uint32
+}
+
+// linkSideModule loads a dynamically linked side module, such as a compiled
+// grammar, into the instance. It places the module's static data in a fresh
+// allocation and its functions in new table slots, resolves its imports
+// against the core module's exports and runs its relocations and
+// constructors, mirroring Emscripten's loadWebAssemblyModule.
+func (ts *TreeSitter) linkSideModule(wasm []byte) (api.Module, error) {
+	ctx := ts.ctx
+	info, err := parseDylink(wasm)
+	if err != nil {
+		return nil, err
+	}
+	imports, err := parseImports(wasm)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := ts.runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile module: %w", err)
+	}
+	importedFunctions := map[string]api.FunctionDefinition{}
+	for _, fn := range compiled.ImportedFunctions() {
+		_, name, _ := fn.Import()
+		importedFunctions[name] = fn
+	}
+
+	// The static data must start out zeroed, as it would in a fresh module.
+	memoryBase := uint32(0)
+	if info.memorySize > 0 {
+		align := uint32(1) << info.memoryAlign
+		res, err := ts.call("calloc", 1, uint64(info.memorySize+align-1))
+		if err != nil {
+			return nil, err
+		}
+		if uint32(res[0]) == 0 {
+			return nil, fmt.Errorf("failed to allocate %d bytes for module data", info.memorySize)
+		}
+		memoryBase = alignUp(uint32(res[0]), info.memoryAlign)
+	}
+
+	gotFuncCount := 0
+	for _, imp := range imports {
+		if imp.module == "GOT.func" {
+			gotFuncCount++
+		}
+	}
+	res, err := ts.env.ExportedFunction(tableGrowName).Call(ctx, uint64(info.tableSize)+uint64(gotFuncCount))
+	if err != nil {
+		return nil, fmt.Errorf("failed to grow table: %w", err)
+	}
+	if int32(res[0]) < 0 {
+		return nil, fmt.Errorf("failed to grow table by %d slots", info.tableSize)
+	}
+	tableBase := uint32(res[0])
+
+	env := &envModule{importFrom: coreEnvName}
+	got := &envModule{importFrom: coreEnvName}
+	var gotMem, gotFunc []gotEntry
+	for _, imp := range imports {
+		name := imp.module + "." + imp.name
+		switch {
+		case imp.module == "env" && (imp.kind == externMemory || imp.kind == externTable):
+			// Always re-exported from the core env module.
+		case imp.module == "env" && imp.kind == externGlobal && imp.name == "__memory_base":
+			env.globals = append(env.globals, envGlobal{name: imp.name, value: memoryBase})
+		case imp.module == "env" && imp.kind == externGlobal && imp.name == "__table_base":
+			env.globals = append(env.globals, envGlobal{name: imp.name, value: tableBase})
+		case imp.module == "env" && imp.kind == externGlobal:
+			if ts.env.ExportedGlobal(imp.name) == nil {
+				return nil, fmt.Errorf("unresolved import %s", name)
+			}
+			env.globals = append(env.globals, envGlobal{module: coreEnvName, name: imp.name, mutable: imp.mutable})
+		case imp.module == "env" && imp.kind == externFunction:
+			if ts.module.ExportedFunction(imp.name) == nil {
+				return nil, fmt.Errorf("unresolved import %s", name)
+			}
+			fn := importedFunctions[imp.name]
+			env.functions = append(env.functions, envFunction{
+				module:  coreModuleName,
+				name:    imp.name,
+				params:  fn.ParamTypes(),
+				results: fn.ResultTypes(),
+			})
+		case imp.module == "GOT.mem" && imp.kind == externGlobal:
+			got.globals = append(got.globals, envGlobal{name: imp.name, mutable: imp.mutable})
+			gotMem = append(gotMem, gotEntry{name: imp.name})
+		case imp.module == "GOT.func" && imp.kind == externGlobal:
+			slot := tableBase + info.tableSize + uint32(len(gotFunc))
+			got.globals = append(got.globals, envGlobal{name: imp.name, mutable: imp.mutable, value: slot})
+			gotFunc = append(gotFunc, gotEntry{name: imp.name, value: slot})
+		default:
+			return nil, fmt.Errorf("unresolved import %s", name)
+		}
+	}
+
+	resolveCore := experimental.WithImportResolver(ctx, func(name string) api.Module {
+		switch name {
+		case coreEnvName:
+			return ts.env
+		case coreModuleName:
+			return ts.module
+		}
+		return nil
+	})
+	envMod, err := ts.runtime.InstantiateWithConfig(resolveCore, env.encode(), wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate env module: %w", err)
+	}
+	ts.modules = append(ts.modules, envMod)
+	gotMod, err := ts.runtime.InstantiateWithConfig(resolveCore, got.encode(), wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate GOT module: %w", err)
+	}
+	ts.modules = append(ts.modules, gotMod)
+	resolveSide := experimental.WithImportResolver(ctx, func(name string) api.Module {
+		switch name {
+		case "env":
+			return envMod
+		case "GOT.mem", "GOT.func":
+			return gotMod
+		}
+		return nil
+	})
+	mod, err := ts.runtime.InstantiateModule(resolveSide, compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate module: %w", err)
+	}
+	ts.modules = append(ts.modules, mod)
+
+	// Data symbols are exported as offsets from the module's memory base.
+	for _, entry := range gotMem {
+		symbol := mod.ExportedGlobal(entry.name)
+		if symbol == nil {
+			return nil, fmt.Errorf("unresolved import GOT.mem.%s", entry.name)
+		}
+		gotMod.ExportedGlobal(entry.name).(api.MutableGlobal).Set(uint64(memoryBase + uint32(symbol.Get())))
+	}
+	if len(gotFunc) > 0 {
+		functions := make([]envFunction, len(gotFunc))
+		for i, entry := range gotFunc {
+			owner, moduleName := mod, sideModuleName
+			if owner.ExportedFunction(entry.name) == nil {
+				owner, moduleName = ts.module, coreModuleName
+			}
+			fn := owner.ExportedFunction(entry.name)
+			if fn == nil {
+				return nil, fmt.Errorf("unresolved import GOT.func.%s", entry.name)
+			}
+			functions[i] = envFunction{
+				module:  moduleName,
+				name:    entry.name,
+				params:  fn.Definition().ParamTypes(),
+				results: fn.Definition().ResultTypes(),
+			}
+		}
+		resolveTable := experimental.WithImportResolver(ctx, func(name string) api.Module {
+			switch name {
+			case coreEnvName:
+				return ts.env
+			case coreModuleName:
+				return ts.module
+			case sideModuleName:
+				return mod
+			}
+			return nil
+		})
+		tableInit := encodeTableInit(coreEnvName, gotFunc[0].value, functions)
+		tableMod, err := ts.runtime.InstantiateWithConfig(resolveTable, tableInit, wazero.NewModuleConfig().WithName(""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to link GOT.func entries: %w", err)
+		}
+		ts.modules = append(ts.modules, tableMod)
+	}
+
+	for _, name := range []string{"__wasm_apply_data_relocs", "__wasm_call_ctors"} {
+		fn := mod.ExportedFunction(name)
+		if fn == nil {
+			continue
+		}
+		if _, err := fn.Call(context.WithValue(ctx, instanceKey{}, ts)); err != nil {
+			return nil, fmt.Errorf("failed to call %s: %w", name, err)
+		}
+	}
+	return mod, nil
+}
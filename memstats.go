@@ -0,0 +1,100 @@
+package treesitter
+
+// MemStats describes the WASM memory in use by a TreeSitter instance, for
+// finding leaks in long-running programs.
+type MemStats struct {
+	// LiveAllocations is the number of allocations not yet freed: blocks
+	// allocated with malloc for a call still in progress or never freed,
+	// and parsers, trees, queries and tree cursors not yet deleted or
+	// released by the garbage collector.
+	LiveAllocations int
+	// BytesAllocated is the total size of the malloc blocks counted in
+	// LiveAllocations.
+	BytesAllocated uint64
+	// HeapSize is the current size of the instance's memory in bytes.
+	HeapSize uint32
+}
+
+// memStats tracks the allocations reported by MemStats. It is updated by
+// callContext and runReleases as calls into the module complete.
+//
+// The core module allocates through an internal function pointer that the
+// host cannot hook, so the memory a parser, tree, query or cursor keeps is
+// counted as one allocation per object, made by its constructor and undone
+// by its destructor. Nodes live in the transfer buffer and their tree, and
+// own no memory of their own. The memory of loaded grammars, allocated with
+// calloc when they are linked, lasts as long as the instance and is not
+// counted.
+type memStats struct {
+	// blocks maps the address of each live malloc block to its size.
+	blocks map[uint32]uint32
+	bytes  uint64
+	// objects is the number of live parsers, trees, queries and cursors.
+	objects int
+	// mallocs counts the calls to malloc made by the host since the
+	// instance started.
+	mallocs uint64
+}
+
+// MemStats returns the instance's current memory statistics. Once every
+// parser, tree, query and tree cursor created from the instance has been
+// deleted, LiveAllocations is 0.
+func (ts *TreeSitter) MemStats() MemStats {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return MemStats{
+		LiveAllocations: len(ts.stats.blocks) + ts.stats.objects,
+		BytesAllocated:  ts.stats.bytes,
+		HeapSize:        ts.memory.Size(),
+	}
+}
+
+// account updates the instance's memory statistics for a completed call to
+// the function name.
+func (ts *TreeSitter) account(name string, params, res []uint64) {
+	s := &ts.stats
+	switch name {
+	case "malloc":
+		s.mallocs++
+		if res[0] == 0 {
+			return
+		}
+		size := uint32(params[0])
+		if s.blocks == nil {
+			s.blocks = make(map[uint32]uint32)
+		}
+		s.blocks[uint32(res[0])] = size
+		s.bytes += uint64(size)
+	case "free":
+		s.untrack(uint32(params[0]))
+	case "ts_parser_new_wasm":
+		if ts.readTransfer(0) != 0 {
+			s.objects++
+		}
+	case "ts_parser_parse_wasm":
+		// The ranges array passed in is freed by the core module.
+		s.untrack(uint32(params[3]))
+		if res[0] != 0 {
+			s.objects++
+		}
+	case "ts_tree_copy", "ts_query_new":
+		if res[0] != 0 {
+			s.objects++
+		}
+	case "ts_tree_cursor_new_wasm":
+		s.objects++
+	case "ts_parser_delete", "ts_tree_delete", "ts_query_delete", "ts_tree_cursor_delete_wasm":
+		s.objects--
+	}
+}
+
+// untrack forgets the malloc block at ptr. Memory allocated by the core
+// module itself and freed by the host is not tracked and is ignored.
+func (s *memStats) untrack(ptr uint32) {
+	size, ok := s.blocks[ptr]
+	if !ok {
+		return
+	}
+	delete(s.blocks, ptr)
+	s.bytes -= uint64(size)
+}
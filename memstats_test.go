@@ -0,0 +1,65 @@
+package treesitter
+
+import "testing"
+
+func TestMemStats(t *testing.T) {
+	p := newTestParser(t, "json")
+	ts := p.ts
+
+	tree, root := parseTest(t, p, `{"a": [1, 2]}`)
+	copied, err := tree.Copy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := root.NewTreeCursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := p.Language().NewQuery(`(number) @n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewQueryCursor()
+	if err := c.Exec(query, root); err != nil {
+		t.Fatal(err)
+	}
+	if matches := cursorMatches(t, c, query, `{"a": [1, 2]}`); len(matches) != 2 {
+		t.Fatalf("matches = %q, want 2", matches)
+	}
+	stats := ts.MemStats()
+	// The parser, two trees, the cursor and the query.
+	if stats.LiveAllocations != 5 {
+		t.Errorf("LiveAllocations = %d, want 5", stats.LiveAllocations)
+	}
+	if stats.BytesAllocated != 0 {
+		t.Errorf("BytesAllocated = %d between calls, want 0", stats.BytesAllocated)
+	}
+	if stats.HeapSize != ts.memory.Size() {
+		t.Errorf("HeapSize = %d, want %d", stats.HeapSize, ts.memory.Size())
+	}
+
+	for _, d := range []interface{ Delete() error }{cursor, query, copied, tree} {
+		if err := d.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := ts.MemStats().LiveAllocations; got != 1 {
+		t.Errorf("LiveAllocations with only the parser left = %d, want 1", got)
+	}
+
+	// A tree not yet deleted stays counted.
+	leaked, _ := parseTest(t, p, `[]`)
+	if got := ts.MemStats().LiveAllocations; got != 2 {
+		t.Errorf("LiveAllocations with a tree left = %d, want 2", got)
+	}
+
+	if err := leaked.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := ts.MemStats(); stats.LiveAllocations != 0 || stats.BytesAllocated != 0 {
+		t.Errorf("MemStats() after deleting everything = %+v, want no allocations", stats)
+	}
+}
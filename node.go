@@ -0,0 +1,770 @@
+package treesitter
+
+import (
+	"fmt"
+	"math"
+)
+
+// Node is a syntax node within a Tree.
+//
+// The core module passes nodes through its transfer buffer as five 32-bit
+// words; Node keeps a copy of those words, so it owns no WASM memory, needs
+// no Delete, and stays valid for as long as its tree does.
+type Node struct {
+	tree *Tree
+	// shift is the shift of the root the node was found from, if that was
+	// returned by Tree.RootNodeWithOffset.
+	shift *rootOffset
+	id    uint32
+	// startByte, startRow and startColumn are the node's start in bytes,
+	// converted from unitStart, startRow and unitColumn, the words the core
+	// module reports it as.
+	startByte   uint32
+	startRow    uint32
+	startColumn uint32
+	unitStart   uint32
+	unitColumn  uint32
+	alias       uint32
+}
+
+// nodeWords is the number of 32-bit words in a marshalled node.
+const nodeWords = 5
+
+// readNode decodes the node currently held in the transfer buffer, found
+// from a node below shift.
+func (t *Tree) readNode(shift *rootOffset) *Node {
+	return t.readNodeAt(t.ts.transferBuffer, shift)
+}
+
+// readNodeAt decodes a node marshalled as five words at address, as in the
+// transfer buffer or the match arrays of ts_query_matches_wasm.
+func (t *Tree) readNodeAt(address uint32, shift *rootOffset) *Node {
+	n := &Node{}
+	t.decodeNode(n, address, shift)
+	return n
+}
+
+// decodeNode overwrites n with the node marshalled at address.
+func (t *Tree) decodeNode(n *Node, address uint32, shift *rootOffset) {
+	var words [nodeWords]uint32
+	for i := range words {
+		words[i], _ = t.ts.memory.ReadUint32Le(address + 4*uint32(i))
+	}
+	p := positions{text: t.offsets, shift: shift}
+	start := p.bytePoint(Point{Row: words[2], Column: words[3]})
+	*n = Node{
+		tree:        t,
+		shift:       shift,
+		id:          words[0],
+		startByte:   p.byteOffset(words[1]),
+		startRow:    start.Row,
+		startColumn: start.Column,
+		unitStart:   words[1],
+		unitColumn:  words[3],
+		alias:       words[4],
+	}
+}
+
+// positions converts between the node's positions in bytes and in code
+// units.
+func (n *Node) positions() positions {
+	return positions{text: n.tree.offsets, shift: n.shift}
+}
+
+// marshal stores the node in the transfer buffer ahead of a ts_node_* call.
+func (n *Node) marshal() {
+	ts := n.tree.ts
+	ts.writeTransfer(0, n.id)
+	ts.writeTransfer(1, n.unitStart)
+	ts.writeTransfer(2, n.startRow)
+	ts.writeTransfer(3, n.unitColumn)
+	ts.writeTransfer(4, n.alias)
+}
+
+// IsNull reports whether the node is the null node, which tree-sitter uses
+// to signal the absence of a node.
+func (n *Node) IsNull() bool {
+	return n.id == 0
+}
+
+// Equal reports whether n and other are the same node of the same tree, as
+// ts_node_eq does. Nodes from a tree and from its copy, or from trees of
+// different instances, are not equal. It fails with ErrDeleted if either
+// node's tree has been deleted.
+//
+// The core module does not export ts_node_eq_wasm, but a node is identified
+// by its tree and its id, both of which Node holds, so no call is needed.
+func (n *Node) Equal(other *Node) (bool, error) {
+	ts := n.tree.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.closed {
+		return false, fmt.Errorf("%w: cannot compare nodes", ErrClosed)
+	}
+	if err := n.tree.checkDeleted(); err != nil {
+		return false, err
+	}
+	if other == nil || other.tree.ts != ts {
+		return false, nil
+	}
+	if err := other.tree.checkDeleted(); err != nil {
+		return false, err
+	}
+	return n.tree.pointer == other.tree.pointer && n.id == other.id, nil
+}
+
+// String returns the node's S-expression.
+func (n *Node) String() (string, error) {
+	ts := n.tree.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := n.tree.checkDeleted(); err != nil {
+		return "", err
+	}
+	n.marshal()
+	res, err := ts.call("ts_node_to_string_wasm", uint64(n.tree.pointer))
+	if err != nil {
+		return "", err
+	}
+	ptr := uint32(res[0])
+	defer ts.free(ptr)
+	// The S-expression of a large tree can be longer than strings from the
+	// grammar are allowed to be; it was just allocated, so the pointer can
+	// be trusted.
+	return ts.readCStringLimit(ptr, math.MaxUint32)
+}
+
+// Type returns the node's type as named in the grammar, such as
+// "function_declaration". The null node has an empty type.
+func (n *Node) Type() (string, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	if n.IsNull() {
+		return "", nil
+	}
+	symbol, err := n.symbol()
+	if err != nil {
+		return "", err
+	}
+	return n.tree.language.symbolName(uint32(symbol))
+}
+
+// GrammarType returns the name of the grammar rule the node was parsed as,
+// ignoring aliases. It differs from Type only where the grammar aliases a
+// rule: for alias($.identifier, $.field_identifier), as in the Go grammar,
+// Type is "field_identifier" and GrammarType "identifier". Use Type for what
+// the node means in the tree and GrammarType for analyses that follow the
+// grammar's rules.
+//
+// The core module does not export ts_node_grammar_type_wasm, so this is the
+// name of GrammarSymbol.
+func (n *Node) GrammarType() (string, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	if n.IsNull() {
+		return "", nil
+	}
+	symbol, ok := n.subtreeSymbol()
+	if !ok {
+		res, err := n.callUint32("ts_node_grammar_symbol_wasm")
+		if err != nil {
+			return "", err
+		}
+		symbol = uint16(res)
+	}
+	return n.tree.language.symbolName(uint32(symbol))
+}
+
+// Symbol returns the id of the node's type, which Language.SymbolName turns
+// back into the name Type returns. Comparing symbols is cheaper than
+// comparing type names.
+//
+// Where the grammar aliases a rule, as in alias($.identifier, $.name), Symbol
+// is the alias the node appears as; GrammarSymbol is the rule it was parsed
+// as.
+func (n *Node) Symbol() (uint16, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.symbol()
+}
+
+// GrammarSymbol returns the id of the grammar rule the node was parsed as,
+// ignoring aliases. Without an alias it is the same as Symbol.
+func (n *Node) GrammarSymbol() (uint16, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	if symbol, ok := n.subtreeSymbol(); ok {
+		return symbol, nil
+	}
+	symbol, err := n.callUint32("ts_node_grammar_symbol_wasm")
+	return uint16(symbol), err
+}
+
+// StartByte returns the offset of the node's first byte.
+//
+// Offsets are in bytes, not runes: use them to index into the UTF-8 source
+// that was parsed. For a tree from Parser.ParseUTF16 they count UTF-16 code
+// units instead.
+//
+// The offset is held in the node itself, so no call into the module is
+// needed; it fails only with ErrDeleted once the tree has been deleted.
+func (n *Node) StartByte() (uint32, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	if err := n.tree.checkDeleted(); err != nil {
+		return 0, err
+	}
+	return n.startByte, nil
+}
+
+// EndByte returns the offset just past the node's last byte. Like StartByte,
+// it is a byte offset into the UTF-8 source.
+func (n *Node) EndByte() (uint32, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	end, err := n.callUint32("ts_node_end_index_wasm")
+	return n.positions().byteOffset(end), err
+}
+
+// callUint32 marshals the node and calls a ts_node_*_wasm function that
+// returns a single value.
+func (n *Node) callUint32(name string) (uint32, error) {
+	if err := n.tree.checkDeleted(); err != nil {
+		return 0, err
+	}
+	n.marshal()
+	res, err := n.tree.ts.call(name, uint64(n.tree.pointer))
+	if err != nil {
+		return 0, err
+	}
+	return uint32(res[0]), nil
+}
+
+// StartPoint returns the row and column where the node starts. The column is
+// measured in bytes from the start of the line.
+//
+// Like StartByte, it is held in the node itself and fails only with
+// ErrDeleted.
+func (n *Node) StartPoint() (Point, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	if err := n.tree.checkDeleted(); err != nil {
+		return Point{}, err
+	}
+	return Point{Row: n.startRow, Column: n.startColumn}, nil
+}
+
+// EndPoint returns the row and column just past the end of the node. The
+// column is measured in bytes from the start of the line.
+func (n *Node) EndPoint() (Point, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	end, err := n.callPoint("ts_node_end_point_wasm")
+	return n.positions().bytePoint(end), err
+}
+
+// Range returns the bytes and points the node spans, as StartByte, EndByte,
+// StartPoint and EndPoint would. The start is held in the node itself, so
+// only the end needs calls into the module: two, where the four accessors
+// make four.
+func (n *Node) Range() (Range, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	end, err := n.callUint32("ts_node_end_index_wasm")
+	if err != nil {
+		return Range{}, err
+	}
+	endPoint, err := n.callPoint("ts_node_end_point_wasm")
+	if err != nil {
+		return Range{}, err
+	}
+	p := n.positions()
+	return Range{
+		StartByte:  n.startByte,
+		EndByte:    p.byteOffset(end),
+		StartPoint: Point{Row: n.startRow, Column: n.startColumn},
+		EndPoint:   p.bytePoint(endPoint),
+	}, nil
+}
+
+// callPoint marshals the node and calls a ts_node_*_wasm function that
+// returns its result as a point in the transfer buffer.
+func (n *Node) callPoint(name string) (Point, error) {
+	if err := n.tree.checkDeleted(); err != nil {
+		return Point{}, err
+	}
+	n.marshal()
+	if _, err := n.tree.ts.call(name, uint64(n.tree.pointer)); err != nil {
+		return Point{}, err
+	}
+	return n.tree.ts.readPoint(), nil
+}
+
+// Edit moves the node's start to where it is after an edit to the source
+// text, as ts_node_edit does: a node starting after the edited bytes shifts
+// with them, and one starting inside them moves to the end of the new text.
+// The node is updated in place; its end follows, since it is computed from
+// the start. Like Tree.Edit, it fails with ErrDeleted once the tree has been
+// deleted.
+//
+// Edit is for advanced incremental scenarios, where a node is held across an
+// edit without fetching it again. Normally Tree.Edit is applied to the tree
+// instead, which also marks the affected nodes as changed for the reparse.
+//
+// The core module does not export ts_node_edit, so the start, which the
+// node holds itself, is adjusted here. It is converted to code units through
+// the tree's current positions, so if the text is not all ASCII, edit the
+// tree first.
+func (n *Node) Edit(edit InputEdit) error {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	if err := n.tree.checkDeleted(); err != nil {
+		return err
+	}
+	start := Point{Row: n.startRow, Column: n.startColumn}
+	if n.startByte >= edit.OldEndByte {
+		n.startByte = edit.NewEndByte + (n.startByte - edit.OldEndByte)
+		start = pointAdd(edit.NewEndPoint, pointSub(start, edit.OldEndPoint))
+	} else if n.startByte > edit.StartByte {
+		n.startByte = edit.NewEndByte
+		start = edit.NewEndPoint
+	}
+	n.startRow, n.startColumn = start.Row, start.Column
+	p := n.positions()
+	n.unitStart, n.unitColumn = p.unitOffset(n.startByte), p.unitPoint(start).Column
+	return nil
+}
+
+// ChildCount returns the number of children of the node, named or not.
+func (n *Node) ChildCount() (uint32, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callUint32("ts_node_child_count_wasm")
+}
+
+// Child returns the node's child at index. It is an error for index to be
+// out of range.
+func (n *Node) Child(index uint32) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	child, err := n.callNode("ts_node_child_wasm", uint64(index))
+	if err != nil {
+		return nil, err
+	}
+	if child.IsNull() {
+		return nil, fmt.Errorf("child index %d out of range", index)
+	}
+	return child, nil
+}
+
+// callNode marshals the node and calls a ts_node_*_wasm function that
+// returns another node in the transfer buffer. The result may be the null
+// node.
+func (n *Node) callNode(name string, params ...uint64) (*Node, error) {
+	if err := n.tree.checkDeleted(); err != nil {
+		return nil, err
+	}
+	n.marshal()
+	if _, err := n.tree.ts.call(name, append([]uint64{uint64(n.tree.pointer)}, params...)...); err != nil {
+		return nil, err
+	}
+	return n.tree.readNode(n.shift), nil
+}
+
+// NamedChildCount returns the number of named children of the node.
+func (n *Node) NamedChildCount() (uint32, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callUint32("ts_node_named_child_count_wasm")
+}
+
+// DescendantCount returns the number of nodes in the subtree rooted at the
+// node: the node itself and all of its descendants, named and anonymous,
+// including extras and ERROR and MISSING nodes. A leaf has a count of 1.
+func (n *Node) DescendantCount() (uint32, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callUint32("ts_node_descendant_count_wasm")
+}
+
+// NamedChild returns the node's named child at index, skipping anonymous
+// nodes such as punctuation. It is an error for index to be out of range.
+func (n *Node) NamedChild(index uint32) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	child, err := n.callNode("ts_node_named_child_wasm", uint64(index))
+	if err != nil {
+		return nil, err
+	}
+	if child.IsNull() {
+		return nil, fmt.Errorf("named child index %d out of range", index)
+	}
+	return child, nil
+}
+
+// Children returns all of the node's children, named and anonymous, in
+// order. Each is a Node of its own, which needs no Delete.
+//
+// This allocates a Node for every child; for nodes with many children, or
+// in hot paths, walk them with a TreeCursor instead.
+func (n *Node) Children() ([]*Node, error) {
+	return n.children(false)
+}
+
+// NamedChildren is like Children, but returns only the named children.
+func (n *Node) NamedChildren() ([]*Node, error) {
+	return n.children(true)
+}
+
+// children collects the node's children, or only the named ones, with a
+// cursor, which visits each child once in place of a call per index.
+func (n *Node) children(namedOnly bool) ([]*Node, error) {
+	ts := n.tree.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	cursor, err := n.newTreeCursor()
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.call("ts_tree_cursor_delete_wasm")
+
+	var children []*Node
+	moved, err := cursor.move("ts_tree_cursor_goto_first_child_wasm")
+	for ; moved && err == nil; moved, err = cursor.move("ts_tree_cursor_goto_next_sibling_wasm") {
+		if namedOnly {
+			named, err := cursor.call("ts_tree_cursor_current_node_is_named_wasm")
+			if err != nil {
+				return nil, err
+			}
+			if named == 0 {
+				continue
+			}
+		}
+		if _, err := cursor.call("ts_tree_cursor_current_node_wasm"); err != nil {
+			return nil, err
+		}
+		children = append(children, n.tree.readNode(n.shift))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return children, nil
+}
+
+// IsNamed reports whether the node is named, meaning it corresponds to a
+// named rule in the grammar rather than an anonymous literal.
+func (n *Node) IsNamed() (bool, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callBool("ts_node_is_named_wasm")
+}
+
+// callBool is like callUint32 for functions that return a C bool.
+func (n *Node) callBool(name string) (bool, error) {
+	v, err := n.callUint32(name)
+	return v != 0, err
+}
+
+// Parent returns the node's parent.
+//
+// tree-sitter reports the root's parent as the null node; Parent returns
+// (nil, nil) in that case, so a loop walking up the tree stops cleanly at the
+// root.
+func (n *Node) Parent() (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callOptionalNode("ts_node_parent_wasm")
+}
+
+// callOptionalNode is like callNode, but returns nil instead of the null
+// node.
+func (n *Node) callOptionalNode(name string, params ...uint64) (*Node, error) {
+	node, err := n.callNode(name, params...)
+	if err != nil || node.IsNull() {
+		return nil, err
+	}
+	return node, nil
+}
+
+// NextSibling returns the node's next sibling, or nil if it is the last
+// child of its parent.
+func (n *Node) NextSibling() (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callOptionalNode("ts_node_next_sibling_wasm")
+}
+
+// PrevSibling returns the node's previous sibling, or nil if it is the first
+// child of its parent.
+func (n *Node) PrevSibling() (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callOptionalNode("ts_node_prev_sibling_wasm")
+}
+
+// NextNamedSibling returns the node's next named sibling, or nil if there is
+// none.
+func (n *Node) NextNamedSibling() (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callOptionalNode("ts_node_next_named_sibling_wasm")
+}
+
+// PrevNamedSibling returns the node's previous named sibling, or nil if there
+// is none.
+func (n *Node) PrevNamedSibling() (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callOptionalNode("ts_node_prev_named_sibling_wasm")
+}
+
+// NextLeaf returns the first leaf, a node without children, that follows the
+// node and all of its descendants in document order, or nil if there is
+// none. Internal nodes are skipped, so starting from the first leaf of a
+// tree and calling NextLeaf until it returns nil visits every token in
+// order, anonymous ones and zero-width MISSING nodes included.
+func (n *Node) NextLeaf() (*Node, error) {
+	return n.adjacentLeaf("ts_node_next_sibling_wasm", "ts_tree_cursor_goto_first_child_wasm")
+}
+
+// PrevLeaf is like NextLeaf, but returns the last leaf that precedes the
+// node.
+func (n *Node) PrevLeaf() (*Node, error) {
+	return n.adjacentLeaf("ts_node_prev_sibling_wasm", "ts_tree_cursor_goto_last_child_wasm")
+}
+
+// adjacentLeaf walks up from the node to the first ancestor-or-self with a
+// sibling in the direction of the ts_node_*_sibling_wasm function sibling,
+// then descends from that sibling to its outermost leaf by repeatedly
+// moving a cursor with the ts_tree_cursor_goto_*_child_wasm function child.
+func (n *Node) adjacentLeaf(sibling, child string) (*Node, error) {
+	ts := n.tree.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	current := n
+	for {
+		next, err := current.callOptionalNode(sibling)
+		if err != nil {
+			return nil, err
+		}
+		if next != nil {
+			current = next
+			break
+		}
+		if current, err = current.callOptionalNode("ts_node_parent_wasm"); current == nil || err != nil {
+			return nil, err
+		}
+	}
+
+	cursor, err := current.newTreeCursor()
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.call("ts_tree_cursor_delete_wasm")
+	for {
+		moved, err := cursor.move(child)
+		if err != nil {
+			return nil, err
+		}
+		if !moved {
+			break
+		}
+	}
+	if _, err := cursor.call("ts_tree_cursor_current_node_wasm"); err != nil {
+		return nil, err
+	}
+	return n.tree.readNode(n.shift), nil
+}
+
+// Text returns the part of source covered by the node. source must be the
+// text the tree was parsed from.
+func (n *Node) Text(source []byte) (string, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	start := n.startByte
+	end, err := n.callUint32("ts_node_end_index_wasm")
+	if err != nil {
+		return "", err
+	}
+	end = n.positions().byteOffset(end)
+	if start > end || end > uint32(len(source)) {
+		return "", fmt.Errorf("node range [%d, %d) is outside the %d-byte source", start, end, len(source))
+	}
+	return string(source[start:end]), nil
+}
+
+// HasError reports whether the node is, or contains, a syntax error.
+func (n *Node) HasError() (bool, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callBool("ts_node_has_error_wasm")
+}
+
+// IsError reports whether the node is an ERROR node, produced when the parser
+// skips text it cannot make sense of.
+func (n *Node) IsError() (bool, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callBool("ts_node_is_error_wasm")
+}
+
+// IsMissing reports whether the node is a MISSING node, inserted by the parser
+// to recover from certain kinds of syntax error. Missing nodes are empty.
+func (n *Node) IsMissing() (bool, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callBool("ts_node_is_missing_wasm")
+}
+
+// IsExtra reports whether the node is an extra, such as a comment, that the
+// grammar allows to appear anywhere rather than as part of a rule.
+func (n *Node) IsExtra() (bool, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callBool("ts_node_is_extra_wasm")
+}
+
+// HasChanges reports whether the node was affected by an edit. It is only
+// meaningful on a tree that has had Tree.Edit applied and has not yet been
+// reparsed.
+func (n *Node) HasChanges() (bool, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callBool("ts_node_has_changes_wasm")
+}
+
+// FieldNameForChild returns the name of the field through which the node's
+// child at index is attached, or "" if it is not attached through a field.
+// It is an error for index to be out of range.
+//
+// The name is copied out of a static string inside the language; there is
+// nothing to free.
+func (n *Node) FieldNameForChild(index uint32) (string, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	count, err := n.callUint32("ts_node_child_count_wasm")
+	if err != nil {
+		return "", err
+	}
+	if index >= count {
+		return "", fmt.Errorf("child index %d out of range", index)
+	}
+	n.marshal()
+	res, err := n.tree.ts.call("ts_node_field_name_for_child_wasm", uint64(n.tree.pointer), uint64(index))
+	if err != nil {
+		return "", err
+	}
+	if uint32(res[0]) == 0 {
+		return "", nil
+	}
+	return n.tree.ts.readCString(uint32(res[0]))
+}
+
+// ChildByFieldName returns the child attached to the node through the named
+// field, such as "name" or "body". It returns (nil, nil) if the node has no
+// such child or the grammar has no such field.
+func (n *Node) ChildByFieldName(name string) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	fieldID, err := n.tree.language.fieldIDForName(name)
+	if err != nil || fieldID == 0 {
+		return nil, err
+	}
+	return n.callOptionalNode("ts_node_child_by_field_id_wasm", uint64(fieldID))
+}
+
+// ChildByFieldID is like ChildByFieldName, but takes the field's id, as
+// returned by Language.FieldIDForName. Looking the id up once saves resolving
+// the name on every call in a loop over many nodes.
+func (n *Node) ChildByFieldID(id uint16) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	if id == 0 {
+		return nil, nil
+	}
+	return n.callOptionalNode("ts_node_child_by_field_id_wasm", uint64(id))
+}
+
+// DescendantForByteRange returns the smallest node within n that spans the
+// bytes from start to end, such as the identifier under a cursor when start
+// and end are both the cursor's offset.
+func (n *Node) DescendantForByteRange(start, end uint32) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	p := n.positions()
+	return n.callDescendant("ts_node_descendant_for_index_wasm", p.unitOffset(start), p.unitOffset(end))
+}
+
+// NamedDescendantForByteRange is like DescendantForByteRange, but returns
+// the smallest named node.
+func (n *Node) NamedDescendantForByteRange(start, end uint32) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	p := n.positions()
+	return n.callDescendant("ts_node_named_descendant_for_index_wasm", p.unitOffset(start), p.unitOffset(end))
+}
+
+// FirstChildForByte returns the node's first child that ends after offset,
+// which is the child containing offset unless offset falls between
+// children, or nil if every child ends at or before offset. Calling it from
+// the root down descends to the node at an offset one level at a time,
+// without a TreeCursor.
+func (n *Node) FirstChildForByte(offset uint32) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callDescendant("ts_node_first_child_for_byte_wasm", n.positions().unitOffset(offset))
+}
+
+// FirstNamedChildForByte is like FirstChildForByte, but returns the first
+// named child.
+func (n *Node) FirstNamedChildForByte(offset uint32) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	return n.callDescendant("ts_node_first_named_child_for_byte_wasm", n.positions().unitOffset(offset))
+}
+
+// callDescendant calls a ts_node_*descendant_for_*_wasm or
+// ts_node_first_*child_for_byte_wasm function, which reads its arguments
+// from the transfer buffer after the node. It returns nil if the function
+// finds no node.
+func (n *Node) callDescendant(name string, args ...uint32) (*Node, error) {
+	ts := n.tree.ts
+	if err := n.tree.checkDeleted(); err != nil {
+		return nil, err
+	}
+	n.marshal()
+	for i, v := range args {
+		ts.writeTransfer(nodeWords+uint32(i), v)
+	}
+	if _, err := ts.call(name, uint64(n.tree.pointer)); err != nil {
+		return nil, err
+	}
+	node := n.tree.readNode(n.shift)
+	if node.IsNull() {
+		return nil, nil
+	}
+	return node, nil
+}
+
+// DescendantForPointRange returns the smallest node within n that spans the
+// part of the source from start to end, given as rows and byte columns.
+func (n *Node) DescendantForPointRange(start, end Point) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	p := n.positions()
+	start, end = p.unitPoint(start), p.unitPoint(end)
+	return n.callDescendant("ts_node_descendant_for_position_wasm", start.Row, start.Column, end.Row, end.Column)
+}
+
+// NamedDescendantForPointRange is like DescendantForPointRange, but returns
+// the smallest named node. With start equal to end it finds the named node
+// at a position, such as the identifier under an editor's cursor; where
+// DescendantForPointRange would return a punctuation token, this returns the
+// node containing it.
+func (n *Node) NamedDescendantForPointRange(start, end Point) (*Node, error) {
+	n.tree.ts.mu.Lock()
+	defer n.tree.ts.mu.Unlock()
+	p := n.positions()
+	start, end = p.unitPoint(start), p.unitPoint(end)
+	return n.callDescendant("ts_node_named_descendant_for_position_wasm", start.Row, start.Column, end.Row, end.Column)
+}
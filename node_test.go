@@ -0,0 +1,558 @@
+package treesitter
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// nodeText returns the text of n in source, failing the test on an error.
+func nodeText(t testing.TB, n *Node, source string) string {
+	t.Helper()
+	text, err := n.Text([]byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+// nodeEqual reports whether a and b are the same node, failing the test on
+// an error.
+func nodeEqual(t testing.TB, a, b *Node) bool {
+	t.Helper()
+	equal, err := a.Equal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return equal
+}
+
+// startByte returns the start of n, failing the test on an error.
+func startByte(t testing.TB, n *Node) uint32 {
+	t.Helper()
+	start, err := n.StartByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return start
+}
+
+// startPoint returns the start point of n, failing the test on an error.
+func startPoint(t testing.TB, n *Node) Point {
+	t.Helper()
+	start, err := n.StartPoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return start
+}
+
+// child returns the child of n at index, failing the test on an error.
+func child(t testing.TB, n *Node, index uint32) *Node {
+	t.Helper()
+	c, err := n.Child(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDescendantForByteRange(t *testing.T) {
+	p := newTestParser(t, "go")
+	source := "package main\n\nfunc hello() {}\n"
+	_, root := parseTest(t, p, source)
+
+	// Byte 21 is inside "hello".
+	n, err := root.DescendantForByteRange(21, 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ, text := nodeType(t, n), nodeText(t, n, source); typ != "identifier" || text != "hello" {
+		t.Errorf("DescendantForByteRange(21, 21) = %s %q, want identifier %q", typ, text, "hello")
+	}
+
+	// Byte 24 is the "(" of the parameter list, an anonymous node.
+	n, err = root.DescendantForByteRange(24, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := nodeType(t, n); typ != "(" {
+		t.Errorf("DescendantForByteRange(24, 24) = %s, want (", typ)
+	}
+	n, err = root.NamedDescendantForByteRange(24, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := nodeType(t, n); typ != "parameter_list" {
+		t.Errorf("NamedDescendantForByteRange(24, 24) = %s, want parameter_list", typ)
+	}
+
+	// A range spanning the name and the body resolves to the declaration.
+	n, err = root.DescendantForByteRange(21, 29)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := nodeType(t, n); typ != "function_declaration" {
+		t.Errorf("DescendantForByteRange(21, 29) = %s, want function_declaration", typ)
+	}
+}
+
+func TestDescendantForPointRange(t *testing.T) {
+	p := newTestParser(t, "go")
+	source := "package main\n\nfunc hello() {\n\tvar ü = wörld\n}\n"
+	_, root := parseTest(t, p, source)
+
+	// Row 3 column 13 is inside "wörld", after the two-byte "ü" and "ö".
+	at := Point{Row: 3, Column: 13}
+	n, err := root.DescendantForPointRange(at, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ, text := nodeType(t, n), nodeText(t, n, source); typ != "identifier" || text != "wörld" {
+		t.Errorf("DescendantForPointRange(%v) = %s %q, want identifier %q", at, typ, text, "wörld")
+	}
+	if start := startPoint(t, n); start != (Point{Row: 3, Column: 10}) {
+		t.Errorf("StartPoint() = %v, want {3 10}", start)
+	}
+
+	// The "=" is anonymous; its named ancestor is the var_spec.
+	at = Point{Row: 3, Column: 8}
+	n, err = root.NamedDescendantForPointRange(at, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := nodeType(t, n); typ != "var_spec" {
+		t.Errorf("NamedDescendantForPointRange(%v) = %s, want var_spec", at, typ)
+	}
+
+	n, err = root.DescendantForPointRange(Point{Row: 2, Column: 5}, Point{Row: 4, Column: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := nodeType(t, n); typ != "function_declaration" {
+		t.Errorf("DescendantForPointRange() across rows 2 to 4 = %s, want function_declaration", typ)
+	}
+}
+
+func TestIsExtraHasChanges(t *testing.T) {
+	p := newTestParser(t, "json")
+	source := "[1, // one\n [2]]"
+	tree, root := parseTest(t, p, source)
+	array := child(t, root, 0)
+	children, err := array.Children()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range children {
+		extra, err := c.IsExtra()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ := nodeType(t, c); extra != (typ == "comment") {
+			t.Errorf("IsExtra() of %s = %v", typ, extra)
+		}
+	}
+	if changed, err := root.HasChanges(); err != nil || changed {
+		t.Errorf("HasChanges() before an edit = %v, %v, want false", changed, err)
+	}
+
+	// Replace the 2 with 3.
+	if err := tree.Edit(InputEdit{
+		StartByte:   13,
+		OldEndByte:  14,
+		NewEndByte:  14,
+		StartPoint:  Point{Row: 1, Column: 2},
+		OldEndPoint: Point{Row: 1, Column: 3},
+		NewEndPoint: Point{Row: 1, Column: 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	root = mustRoot(t, tree)
+	array = child(t, root, 0)
+	first, inner := child(t, array, 1), child(t, array, 4)
+	for _, tt := range []struct {
+		node *Node
+		want bool
+	}{{root, true}, {array, true}, {inner, true}, {first, false}} {
+		if changed, err := tt.node.HasChanges(); err != nil || changed != tt.want {
+			t.Errorf("HasChanges() of %s after the edit = %v, %v, want %v", nodeString(t, tt.node), changed, err, tt.want)
+		}
+	}
+}
+
+func TestChildByFieldID(t *testing.T) {
+	p := newTestParser(t, "json")
+	source := `{"a": 1}`
+	_, root := parseTest(t, p, source)
+	pair := child(t, child(t, root, 0), 1)
+	id, err := p.Language().FieldIDForName("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byID, err := pair.ChildByFieldID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName, err := pair.ChildByFieldName("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byID == nil || !nodeEqual(t, byID, byName) || nodeText(t, byID, source) != "1" {
+		t.Errorf("ChildByFieldID(%d) = %v, want the value 1 found by name", id, byID)
+	}
+
+	// The array has no fields.
+	_, root = parseTest(t, p, `[1]`)
+	missing, err := child(t, root, 0).ChildByFieldID(id)
+	if err != nil || missing != nil {
+		t.Errorf("ChildByFieldID() of an absent field = %v, %v, want nil, nil", missing, err)
+	}
+}
+
+// BenchmarkChildByField compares looking up the value of a pair by field
+// name and by a field id resolved once.
+func BenchmarkChildByField(b *testing.B) {
+	p := newTestParser(b, "json")
+	_, root := parseTest(b, p, `{"a": 1}`)
+	pair := child(b, child(b, root, 0), 1)
+	b.Run("name", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := pair.ChildByFieldName("value"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("id", func(b *testing.B) {
+		id, err := p.Language().FieldIDForName("value")
+		if err != nil {
+			b.Fatal(err)
+		}
+		for b.Loop() {
+			if _, err := pair.ChildByFieldID(id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDescendantCount(t *testing.T) {
+	p := newTestParser(t, "json")
+	// document, array, "[", number, ",", number and "]".
+	_, root := parseTest(t, p, `[1, 2]`)
+	for _, tt := range []struct {
+		node *Node
+		want uint32
+	}{
+		{root, 7},
+		{child(t, root, 0), 6},
+		{child(t, child(t, root, 0), 1), 1},
+	} {
+		if count, err := tt.node.DescendantCount(); err != nil || count != tt.want {
+			t.Errorf("DescendantCount() of %s = %d, %v, want %d", nodeString(t, tt.node), count, err, tt.want)
+		}
+	}
+}
+
+func TestNamedDescendantForPointRange(t *testing.T) {
+	p := newTestParser(t, "go")
+	source := "package main\n\nfunc main() {\n\tfmt.Println(greeting)\n}\n"
+	_, root := parseTest(t, p, source)
+
+	// A zero-width point inside "greeting", and one on the "(" before it,
+	// which is anonymous and resolves to the argument list.
+	for _, tt := range []struct {
+		at   Point
+		typ  string
+		text string
+	}{
+		{Point{Row: 3, Column: 16}, "identifier", "greeting"},
+		{Point{Row: 3, Column: 12}, "argument_list", "(greeting)"},
+	} {
+		n, err := root.NamedDescendantForPointRange(tt.at, tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ, text := nodeType(t, n), nodeText(t, n, source); typ != tt.typ || text != tt.text {
+			t.Errorf("NamedDescendantForPointRange(%v) = %s %q, want %s %q", tt.at, typ, text, tt.typ, tt.text)
+		}
+	}
+}
+
+func TestChildren(t *testing.T) {
+	p := newTestParser(t, "json")
+	source := `{"a": [1, true], "b": null}`
+	_, root := parseTest(t, p, source)
+	object := child(t, root, 0)
+
+	types := func(nodes []*Node) []string {
+		var s []string
+		for _, n := range nodes {
+			s = append(s, nodeType(t, n))
+		}
+		return s
+	}
+	children, err := object.Children()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := types(children), []string{"{", "pair", ",", "pair", "}"}; !slices.Equal(got, want) {
+		t.Errorf("Children() = %q, want %q", got, want)
+	}
+	named, err := object.NamedChildren()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(named) != 2 || nodeText(t, named[0], source) != `"a": [1, true]` || nodeText(t, named[1], source) != `"b": null` {
+		t.Errorf("NamedChildren() = %q, want the two pairs", types(named))
+	}
+	// The nodes are independent of each other and of the cursor that found
+	// them.
+	if !nodeEqual(t, named[0], children[1]) || named[0] == children[1] {
+		t.Error("NamedChildren()[0] is not a separate node equal to Children()[1]")
+	}
+
+	leaf := child(t, child(t, named[0], 2), 1)
+	if nodes, err := leaf.Children(); err != nil || len(nodes) != 0 {
+		t.Errorf("Children() of %s = %q, %v, want none", nodeType(t, leaf), types(nodes), err)
+	}
+}
+
+func TestNextLeaf(t *testing.T) {
+	p := newTestParser(t, "go")
+	source := "package p\n\nvar x = -a + b*(2)\n"
+	_, root := parseTest(t, p, source)
+
+	first := root
+	for {
+		count, err := first.ChildCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count == 0 {
+			break
+		}
+		first = child(t, first, 0)
+	}
+	var tokens []string
+	last := first
+	for n := first; n != nil; {
+		tokens = append(tokens, nodeText(t, n, source))
+		last = n
+		var err error
+		if n, err = n.NextLeaf(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"package", "p", "var", "x", "=", "-", "a", "+", "b", "*", "(", "2", ")"}
+	if !slices.Equal(tokens, want) {
+		t.Errorf("tokens from NextLeaf() = %q, want %q", tokens, want)
+	}
+
+	var back []string
+	for n := last; n != nil; {
+		back = append(back, nodeText(t, n, source))
+		var err error
+		if n, err = n.PrevLeaf(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slices.Reverse(back)
+	if !slices.Equal(back, want) {
+		t.Errorf("tokens from PrevLeaf() = %q, want %q", back, want)
+	}
+
+	// From an internal node, the next leaf follows all of its descendants.
+	clause := child(t, root, 0)
+	if next, err := clause.NextLeaf(); err != nil || next == nil || nodeText(t, next, source) != "var" {
+		t.Errorf("NextLeaf() of %s = %v, %v, want var", nodeType(t, clause), next, err)
+	}
+}
+
+func TestNodeRange(t *testing.T) {
+	p := newTestParser(t, "go")
+	source := "package main\n\n// é😀\nvar s = \"ü\" + x\n"
+	_, root := parseTest(t, p, source)
+	visited := 0
+	if err := root.Walk(func(n *Node, depth int) bool {
+		visited++
+		r, err := n.Range()
+		if err != nil {
+			t.Fatal(err)
+		}
+		end, err := n.EndByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		endPoint, err := n.EndPoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Range{StartByte: startByte(t, n), EndByte: end, StartPoint: startPoint(t, n), EndPoint: endPoint}
+		if r != want {
+			t.Errorf("Range() of %s = %v, want %v", nodeType(t, n), r, want)
+		}
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if visited < 10 {
+		t.Errorf("visited %d nodes, want the whole tree", visited)
+	}
+
+	// The string literal, after the two-byte and four-byte characters of the
+	// comment and with one of its own.
+	str, err := root.DescendantForByteRange(32, 36)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Range{StartByte: 32, EndByte: 36, StartPoint: Point{Row: 3, Column: 8}, EndPoint: Point{Row: 3, Column: 12}}
+	if got, err := str.Range(); err != nil || got != want || nodeType(t, str) != "interpreted_string_literal" {
+		t.Errorf("Range() of %s = %v, %v, want %v", nodeType(t, str), got, err, want)
+	}
+}
+
+func TestFirstChildForByte(t *testing.T) {
+	p := newTestParser(t, "json")
+	source := `{"é": [1, 22]}`
+	_, root := parseTest(t, p, source)
+
+	// Descend from the root to the number at offset 12, one level at a time.
+	var path []string
+	for n := root; n != nil; {
+		path = append(path, nodeType(t, n))
+		var err error
+		if n, err = n.FirstNamedChildForByte(12); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"document", "object", "pair", "array", "number"}; !slices.Equal(path, want) {
+		t.Errorf("path to offset 12 = %q, want %q", path, want)
+	}
+
+	array := child(t, child(t, child(t, root, 0), 1), 2)
+	for _, tt := range []struct {
+		offset       uint32
+		first, named string
+	}{
+		{8, "1", "1"},
+		{9, ",", "22"},
+		{10, "22", "22"},
+	} {
+		if n, err := array.FirstChildForByte(tt.offset); err != nil || n == nil || nodeText(t, n, source) != tt.first {
+			t.Errorf("FirstChildForByte(%d) = %v, %v, want %q", tt.offset, n, err, tt.first)
+		}
+		if n, err := array.FirstNamedChildForByte(tt.offset); err != nil || n == nil || nodeText(t, n, source) != tt.named {
+			t.Errorf("FirstNamedChildForByte(%d) = %v, %v, want %q", tt.offset, n, err, tt.named)
+		}
+	}
+	if n, err := array.FirstChildForByte(14); n != nil || err != nil {
+		t.Errorf("FirstChildForByte() past the array = %v, %v, want nil, nil", n, err)
+	}
+}
+
+func TestGrammarType(t *testing.T) {
+	p := newTestParser(t, "go")
+	source := "package main\n\nfunc (r T) method() {}\n"
+	_, root := parseTest(t, p, source)
+	method := child(t, root, 1)
+	name, err := method.ChildByFieldName("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Go grammar aliases method names from identifier.
+	grammarType, err := name.GrammarType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := nodeType(t, name); typ != "field_identifier" || grammarType != "identifier" {
+		t.Errorf("Type() = %s and GrammarType() = %s of %q, want field_identifier and identifier", typ, grammarType, nodeText(t, name, source))
+	}
+	// Without an alias, the two agree.
+	receiver, err := method.ChildByFieldName("receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grammarType, err := receiver.GrammarType(); err != nil || grammarType != nodeType(t, receiver) {
+		t.Errorf("GrammarType() of %s = %s, %v, want the same as Type", nodeType(t, receiver), grammarType, err)
+	}
+}
+
+func TestNodeEdit(t *testing.T) {
+	p := newTestParser(t, "json")
+	_, root := parseTest(t, p, `[1, 2]`)
+	array := child(t, root, 0)
+	one, two := child(t, array, 1), child(t, array, 3)
+
+	// Insert "10, " before the 1.
+	edit := InputEdit{
+		StartByte: 1, OldEndByte: 1, NewEndByte: 5,
+		StartPoint: Point{Column: 1}, OldEndPoint: Point{Column: 1}, NewEndPoint: Point{Column: 5},
+	}
+	for _, n := range []*Node{one, two, array} {
+		if err := n.Edit(edit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if startByte(t, one) != 5 || startPoint(t, one) != (Point{Column: 5}) {
+		t.Errorf("1 starts at %d %v after the insertion, want 5", startByte(t, one), startPoint(t, one))
+	}
+	if startByte(t, two) != 8 || startPoint(t, two) != (Point{Column: 8}) {
+		t.Errorf("2 starts at %d %v after the insertion, want 8", startByte(t, two), startPoint(t, two))
+	}
+	if startByte(t, array) != 0 {
+		t.Errorf("the array, before the insertion, starts at %d, want 0", startByte(t, array))
+	}
+
+	// Replace "[10, 1, " with "[\n".
+	edit = InputEdit{
+		StartByte: 0, OldEndByte: 8, NewEndByte: 2,
+		StartPoint: Point{}, OldEndPoint: Point{Column: 8}, NewEndPoint: Point{Row: 1},
+	}
+	if err := two.Edit(edit); err != nil {
+		t.Fatal(err)
+	}
+	if startByte(t, two) != 2 || startPoint(t, two) != (Point{Row: 1}) {
+		t.Errorf("2 starts at %d %v after the replacement, want 2 (1, 0)", startByte(t, two), startPoint(t, two))
+	}
+	if err := one.Edit(edit); err != nil {
+		t.Fatal(err)
+	}
+	if startByte(t, one) != 2 {
+		t.Errorf("1, inside the replaced text, starts at %d, want its end 2", startByte(t, one))
+	}
+}
+
+func TestNodeEqual(t *testing.T) {
+	p := newTestParser(t, "json")
+	tree, root := parseTest(t, p, `{"a":1}`)
+	again := mustRoot(t, tree)
+	if !nodeEqual(t, root, again) || nodeEqual(t, root, child(t, root, 0)) || nodeEqual(t, root, nil) {
+		t.Error("Equal() does not tell the root apart from its child")
+	}
+	copied, err := tree.Copy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copiedRoot := mustRoot(t, copied)
+	if nodeEqual(t, root, copiedRoot) {
+		t.Error("the roots of a tree and its copy are equal")
+	}
+
+	// The same text parsed in another instance gives a tree, and nodes, at
+	// the same addresses.
+	_, other := parseTest(t, newTestParser(t, "json"), `{"a":1}`)
+	if nodeEqual(t, root, other) || nodeEqual(t, other, root) {
+		t.Error("the roots of trees in two instances are equal")
+	}
+
+	if err := copied.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.Equal(copiedRoot); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Equal() with a node of a deleted tree: %v, want ErrDeleted", err)
+	}
+	if _, err := copiedRoot.Equal(root); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Equal() of a node of a deleted tree: %v, want ErrDeleted", err)
+	}
+}
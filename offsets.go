@@ -0,0 +1,424 @@
+package treesitter
+
+import (
+	"cmp"
+	"encoding/binary"
+	"math"
+	"slices"
+	"sort"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The core module reads its input as UTF-16 and reports positions in UTF-16
+// code units, both offsets and the columns of points. UTF-8 text is
+// transcoded as the core module reads it, and positions are converted where
+// they cross into and out of the module, so that every offset and column the
+// wrapper hands out or takes is in bytes. Rows need no conversion: lines end
+// at "\n" in both encodings.
+
+// textOffsets maps the byte positions of a UTF-8 text to the code unit
+// positions of its UTF-16 form, and back. Only multibyte characters make the
+// two differ, so it records where those are, as runs of characters of the
+// same size on one line. A nil *textOffsets maps every position to itself,
+// as for ASCII text and for trees parsed from UTF-16.
+type textOffsets struct {
+	runs []charRun
+}
+
+// charRun is a run of count consecutive characters on one line, each size
+// bytes long in UTF-8, starting at the given offset and column in bytes and
+// in code units.
+type charRun struct {
+	byteOffset uint32
+	unitOffset uint32
+	row        uint32
+	byteColumn uint32
+	unitColumn uint32
+	size       uint32
+	count      uint32
+}
+
+// unitSize is the number of code units each character of the run takes:
+// two for the four-byte characters outside the Basic Multilingual Plane,
+// which UTF-16 encodes as surrogate pairs, and one for the others.
+func (r *charRun) unitSize() uint32 {
+	if r.size == 4 {
+		return 2
+	}
+	return 1
+}
+
+// drop returns the run without its first k characters.
+func (r charRun) drop(k uint32) charRun {
+	r.byteOffset += k * r.size
+	r.unitOffset += k * r.unitSize()
+	r.byteColumn += k * r.size
+	r.unitColumn += k * r.unitSize()
+	r.count -= k
+	return r
+}
+
+// offsetsBuilder scans a text to build its textOffsets.
+type offsetsBuilder struct {
+	runs []charRun
+	// nonASCII is set once a byte outside ASCII has been seen.
+	nonASCII bool
+	// The position reached so far.
+	bytes      uint32
+	units      uint32
+	row        uint32
+	byteColumn uint32
+	unitColumn uint32
+}
+
+// scanText returns the offsets of text and reports whether text is all
+// ASCII, so that it can be handed to the core module one byte per code unit.
+func scanText(text string) (*textOffsets, bool) {
+	var b offsetsBuilder
+	b.add(text, true)
+	return b.finish()
+}
+
+// add scans s, the next part of the text, and returns how many of its bytes
+// it consumed. Unless atEOF is set, a character cut off at the end of s is
+// left for the next call.
+//
+// An invalid byte is read as U+FFFD, as writeUTF16 transcodes it, which
+// takes one code unit for the one byte.
+func (b *offsetsBuilder) add(s string, atEOF bool) int {
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			b.advance(1, 1)
+			if c == '\n' {
+				b.row++
+				b.byteColumn, b.unitColumn = 0, 0
+			}
+			i++
+			continue
+		}
+		b.nonASCII = true
+		if !atEOF && !utf8.FullRuneInString(s[i:]) {
+			return i
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r != utf8.RuneError || size != 1 {
+			b.addChar(uint32(size))
+		}
+		b.advance(uint32(size), uint32(utf16.RuneLen(r)))
+		i += size
+	}
+	return len(s)
+}
+
+// addChar records a multibyte character of size bytes at the current
+// position, extending the last run if the character continues it.
+func (b *offsetsBuilder) addChar(size uint32) {
+	if n := len(b.runs); n > 0 {
+		last := &b.runs[n-1]
+		if last.size == size && last.row == b.row && last.byteOffset+last.count*size == b.bytes {
+			last.count++
+			return
+		}
+	}
+	b.runs = append(b.runs, charRun{
+		byteOffset: b.bytes,
+		unitOffset: b.units,
+		row:        b.row,
+		byteColumn: b.byteColumn,
+		unitColumn: b.unitColumn,
+		size:       size,
+		count:      1,
+	})
+}
+
+// advance moves the position past a character of the given sizes.
+func (b *offsetsBuilder) advance(bytes, units uint32) {
+	b.bytes += bytes
+	b.units += units
+	b.byteColumn += bytes
+	b.unitColumn += units
+}
+
+// finish returns the offsets of the text scanned and reports whether it was
+// all ASCII.
+func (b *offsetsBuilder) finish() (*textOffsets, bool) {
+	if len(b.runs) == 0 {
+		return nil, !b.nonASCII
+	}
+	return &textOffsets{runs: b.runs}, false
+}
+
+// unitOffset converts a byte offset into the text to a code unit offset. An
+// offset inside a character is moved to the start of the character.
+func (t *textOffsets) unitOffset(offset uint32) uint32 {
+	if t == nil {
+		return offset
+	}
+	i := sort.Search(len(t.runs), func(i int) bool { return t.runs[i].byteOffset > offset }) - 1
+	if i < 0 {
+		return offset
+	}
+	r := &t.runs[i]
+	return convertInRun(offset, r.byteOffset, r.unitOffset, r.size, r.unitSize(), r.count)
+}
+
+// byteOffset converts a code unit offset into the text to a byte offset,
+// the inverse of unitOffset.
+func (t *textOffsets) byteOffset(offset uint32) uint32 {
+	if t == nil {
+		return offset
+	}
+	i := sort.Search(len(t.runs), func(i int) bool { return t.runs[i].unitOffset > offset }) - 1
+	if i < 0 {
+		return offset
+	}
+	r := &t.runs[i]
+	return convertInRun(offset, r.unitOffset, r.byteOffset, r.unitSize(), r.size, r.count)
+}
+
+// unitPoint converts a point whose column counts bytes to one whose column
+// counts code units.
+func (t *textOffsets) unitPoint(p Point) Point {
+	if t == nil {
+		return p
+	}
+	i := sort.Search(len(t.runs), func(i int) bool {
+		r := &t.runs[i]
+		return r.row > p.Row || r.row == p.Row && r.byteColumn > p.Column
+	}) - 1
+	if i < 0 || t.runs[i].row != p.Row {
+		return p
+	}
+	r := &t.runs[i]
+	return Point{Row: p.Row, Column: convertInRun(p.Column, r.byteColumn, r.unitColumn, r.size, r.unitSize(), r.count)}
+}
+
+// bytePoint converts a point whose column counts code units to one whose
+// column counts bytes, the inverse of unitPoint.
+func (t *textOffsets) bytePoint(p Point) Point {
+	if t == nil {
+		return p
+	}
+	i := sort.Search(len(t.runs), func(i int) bool {
+		r := &t.runs[i]
+		return r.row > p.Row || r.row == p.Row && r.unitColumn > p.Column
+	}) - 1
+	if i < 0 || t.runs[i].row != p.Row {
+		return p
+	}
+	r := &t.runs[i]
+	return Point{Row: p.Row, Column: convertInRun(p.Column, r.unitColumn, r.byteColumn, r.unitSize(), r.size, r.count)}
+}
+
+// convertInRun converts x, an offset or column at or after from, the start
+// of a run of count characters, to the other encoding, in which the run
+// starts at to. Each character takes fromSize in the encoding of x and
+// toSize in the other. The result saturates at the largest offset, so
+// that the sentinels used for "the end of the text" stay past any real
+// position.
+func convertInRun(x, from, to, fromSize, toSize, count uint32) uint32 {
+	if k := (x - from) / fromSize; k < count {
+		return to + k*toSize
+	}
+	return uint32(min(uint64(to+count*toSize)+uint64(x-from-count*fromSize), math.MaxUint32))
+}
+
+// edit returns the offsets of the text after edit, given in bytes and, as
+// units, in code units. The text the edit inserts is unknown, so it is
+// counted as one code unit per byte; see Tree.matchText.
+func (t *textOffsets) edit(edit, units InputEdit) *textOffsets {
+	if t == nil {
+		return nil
+	}
+	var runs []charRun
+	for _, r := range t.runs {
+		end := r.byteOffset + r.count*r.size
+		if r.byteOffset < edit.StartByte {
+			before := r
+			if end > edit.StartByte {
+				before.count = (edit.StartByte - r.byteOffset) / r.size
+			}
+			if before.count > 0 {
+				runs = append(runs, before)
+			}
+		}
+		if end > edit.OldEndByte {
+			after := r
+			if r.byteOffset < edit.OldEndByte {
+				after = r.drop((edit.OldEndByte - r.byteOffset + r.size - 1) / r.size)
+			}
+			if after.count == 0 {
+				continue
+			}
+			bytePoint := shiftPoint(Point{Row: after.row, Column: after.byteColumn}, edit)
+			unitPoint := shiftPoint(Point{Row: after.row, Column: after.unitColumn}, units)
+			after.byteOffset = edit.NewEndByte + (after.byteOffset - edit.OldEndByte)
+			after.unitOffset = units.NewEndByte + (after.unitOffset - units.OldEndByte)
+			after.row, after.byteColumn, after.unitColumn = bytePoint.Row, bytePoint.Column, unitPoint.Column
+			runs = append(runs, after)
+		}
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+	return &textOffsets{runs: runs}
+}
+
+// shiftPoint moves p, which lies at or after the end of the text replaced by
+// edit, to where it is after the edit.
+func shiftPoint(p Point, edit InputEdit) Point {
+	return pointAdd(edit.NewEndPoint, pointSub(p, edit.OldEndPoint))
+}
+
+// editSpans returns spans, the sorted ranges of inserted text recorded for a
+// tree, after edit, together with the text edit inserts.
+func editSpans(spans []Range, edit InputEdit) []Range {
+	var edited []Range
+	for _, s := range spans {
+		if s.StartByte < edit.StartByte {
+			before := s
+			if before.EndByte > edit.StartByte {
+				before.EndByte, before.EndPoint = edit.StartByte, edit.StartPoint
+			}
+			edited = append(edited, before)
+		}
+		if s.EndByte > edit.OldEndByte {
+			after := s
+			if after.StartByte < edit.OldEndByte {
+				after.StartByte, after.StartPoint = edit.OldEndByte, edit.OldEndPoint
+			}
+			after.StartByte = edit.NewEndByte + (after.StartByte - edit.OldEndByte)
+			after.EndByte = edit.NewEndByte + (after.EndByte - edit.OldEndByte)
+			after.StartPoint = shiftPoint(after.StartPoint, edit)
+			after.EndPoint = shiftPoint(after.EndPoint, edit)
+			edited = append(edited, after)
+		}
+	}
+	if edit.NewEndByte > edit.StartByte {
+		edited = append(edited, Range{
+			StartByte:  edit.StartByte,
+			EndByte:    edit.NewEndByte,
+			StartPoint: edit.StartPoint,
+			EndPoint:   edit.NewEndPoint,
+		})
+	}
+	slices.SortFunc(edited, func(a, b Range) int { return cmp.Compare(a.StartByte, b.StartByte) })
+	return edited
+}
+
+// positions converts the positions of a tree's nodes between bytes and code
+// units: through the offsets of the tree's text and, below a root node from
+// Tree.RootNodeWithOffset, around the shift the root was given.
+type positions struct {
+	text  *textOffsets
+	shift *rootOffset
+}
+
+// rootOffset is the shift given to Tree.RootNodeWithOffset. The core module
+// adds it to the positions of the root and of every node found from it, so
+// the text before the tree counts one code unit per byte.
+type rootOffset struct {
+	bytes uint32
+	point Point
+}
+
+// unitOffset converts a byte offset to a code unit offset.
+func (p positions) unitOffset(offset uint32) uint32 {
+	if p.text == nil {
+		return offset
+	}
+	if p.shift == nil || offset < p.shift.bytes {
+		return p.text.unitOffset(offset)
+	}
+	return p.shift.bytes + p.text.unitOffset(offset-p.shift.bytes)
+}
+
+// byteOffset converts a code unit offset to a byte offset.
+func (p positions) byteOffset(offset uint32) uint32 {
+	if p.text == nil {
+		return offset
+	}
+	if p.shift == nil || offset < p.shift.bytes {
+		return p.text.byteOffset(offset)
+	}
+	return uint32(min(uint64(p.shift.bytes)+uint64(p.text.byteOffset(offset-p.shift.bytes)), math.MaxUint32))
+}
+
+// unitPoint converts a point whose column counts bytes to one whose column
+// counts code units.
+func (p positions) unitPoint(point Point) Point {
+	if p.text == nil {
+		return point
+	}
+	if p.shift == nil || pointBefore(point, p.shift.point) {
+		return p.text.unitPoint(point)
+	}
+	return pointAdd(p.shift.point, p.text.unitPoint(pointSub(point, p.shift.point)))
+}
+
+// bytePoint converts a point whose column counts code units to one whose
+// column counts bytes.
+func (p positions) bytePoint(point Point) Point {
+	if p.text == nil {
+		return point
+	}
+	if p.shift == nil || pointBefore(point, p.shift.point) {
+		return p.text.bytePoint(point)
+	}
+	return pointAdd(p.shift.point, p.text.bytePoint(pointSub(point, p.shift.point)))
+}
+
+// unitRange converts a range in bytes to one in code units.
+func (p positions) unitRange(r Range) Range {
+	return Range{
+		StartByte:  p.unitOffset(r.StartByte),
+		EndByte:    p.unitOffset(r.EndByte),
+		StartPoint: p.unitPoint(r.StartPoint),
+		EndPoint:   p.unitPoint(r.EndPoint),
+	}
+}
+
+// byteRange converts a range in code units to one in bytes.
+func (p positions) byteRange(r Range) Range {
+	return Range{
+		StartByte:  p.byteOffset(r.StartByte),
+		EndByte:    p.byteOffset(r.EndByte),
+		StartPoint: p.bytePoint(r.StartPoint),
+		EndPoint:   p.bytePoint(r.EndPoint),
+	}
+}
+
+// pointBefore reports whether a comes before b.
+func pointBefore(a, b Point) bool {
+	return a.Row < b.Row || a.Row == b.Row && a.Column < b.Column
+}
+
+// writeUTF16 transcodes the start of s, UTF-8 text, into buffer as
+// little-endian UTF-16, up to maxChunkUnits code units, and returns how many
+// it wrote. Unless atEOF is set, a character cut off at the end of s is left
+// out. Invalid bytes are written as U+FFFD, one for each byte.
+func (ts *TreeSitter) writeUTF16(buffer uint32, s string, atEOF bool) uint32 {
+	units := make([]byte, 0, 2*maxChunkUnits)
+	for i := 0; i < len(s); {
+		r, size := rune(s[i]), 1
+		if r >= utf8.RuneSelf {
+			if !atEOF && !utf8.FullRuneInString(s[i:]) {
+				break
+			}
+			r, size = utf8.DecodeRuneInString(s[i:])
+		}
+		if len(units)+2*utf16.RuneLen(r) > cap(units) {
+			break
+		}
+		if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+			units = binary.LittleEndian.AppendUint16(units, uint16(r1))
+			units = binary.LittleEndian.AppendUint16(units, uint16(r2))
+		} else {
+			units = binary.LittleEndian.AppendUint16(units, uint16(r))
+		}
+		i += size
+	}
+	ts.memory.Write(buffer, units)
+	return uint32(len(units) / 2)
+}
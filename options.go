@@ -0,0 +1,97 @@
+package treesitter
+
+import (
+	"fmt"
+	"io"
+)
+
+// defaultMaxStringLength is the default for WithMaxStringLength.
+const defaultMaxStringLength = 1 << 20
+
+// Option configures the instances created by New, NewFromWasm and
+// NewEngine.
+type Option func(*options)
+
+// options holds the settings made by the Options given to a constructor.
+type options struct {
+	minMemoryPages uint32
+	maxMemoryPages uint32
+	debugLog       io.Writer
+	// stdout and stderr receive what the core module writes to standard
+	// output and standard error through WASI.
+	stdout io.Writer
+	stderr io.Writer
+	// maxStringLength is the longest C string read from memory.
+	maxStringLength uint32
+}
+
+// WithMinMemoryPages makes each instance start with n pages of 64 KiB of
+// linear memory, so that a large parse does not have to grow it step by
+// step. It must be at least 512, the minimum the core module declares, which
+// is also the default.
+func WithMinMemoryPages(n uint32) Option {
+	return func(o *options) { o.minMemoryPages = n }
+}
+
+// WithMaxMemoryPages caps the linear memory of each instance at n pages of
+// 64 KiB. Once the cap is reached, allocations inside the core module fail
+// and the call that needed them returns an error. It must be at most 32768,
+// the maximum the core module declares, which is also the default, and no
+// lower than the minimum.
+func WithMaxMemoryPages(n uint32) Option {
+	return func(o *options) { o.maxMemoryPages = n }
+}
+
+// WithDebugLogging makes the host functions the core module calls report
+// what they do to w, one line at a time: when the memory grows or fails to,
+// and when the core module aborts. Without it they are silent. Lines from
+// every instance of an engine go to the same w, one at a time.
+func WithDebugLogging(w io.Writer) Option {
+	return func(o *options) { o.debugLog = w }
+}
+
+// WithStdout makes each instance's writes to standard output, made through
+// WASI by the core module and the grammars linked into it, go to w. By
+// default they are discarded.
+func WithStdout(w io.Writer) Option {
+	return func(o *options) { o.stdout = w }
+}
+
+// WithStderr is like WithStdout for standard error.
+func WithStderr(w io.Writer) Option {
+	return func(o *options) { o.stderr = w }
+}
+
+// WithMaxStringLength caps the length of the NUL-terminated strings read
+// from an instance's memory, such as symbol, field and capture names and log
+// messages, at n bytes. A longer string, or a bad pointer to memory with no
+// NUL within n bytes, is reported as an error wrapping ErrMemoryRead. The
+// default is 1 MiB; n must be at least 1.
+func WithMaxStringLength(n uint32) Option {
+	return func(o *options) { o.maxStringLength = n }
+}
+
+// newOptions applies opts to the defaults and checks the result.
+func newOptions(opts []Option) (*options, error) {
+	o := &options{
+		minMemoryPages:  minMemoryPages,
+		maxMemoryPages:  maxMemoryPages,
+		maxStringLength: defaultMaxStringLength,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.minMemoryPages < minMemoryPages {
+		return nil, fmt.Errorf("minimum memory of %d pages is below the core module's minimum of %d", o.minMemoryPages, minMemoryPages)
+	}
+	if o.maxMemoryPages > maxMemoryPages {
+		return nil, fmt.Errorf("maximum memory of %d pages is above the core module's maximum of %d", o.maxMemoryPages, maxMemoryPages)
+	}
+	if o.minMemoryPages > o.maxMemoryPages {
+		return nil, fmt.Errorf("minimum memory of %d pages is above the maximum of %d", o.minMemoryPages, o.maxMemoryPages)
+	}
+	if o.maxStringLength == 0 {
+		return nil, fmt.Errorf("maximum string length of 0 bytes leaves no string readable")
+	}
+	return o, nil
+}
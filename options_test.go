@@ -0,0 +1,55 @@
+package treesitter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewOptionsInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"min below the core's", []Option{WithMinMemoryPages(minMemoryPages - 1)}},
+		{"max above the core's", []Option{WithMaxMemoryPages(maxMemoryPages + 1)}},
+		{"min above max", []Option{WithMinMemoryPages(1024), WithMaxMemoryPages(600)}},
+		{"a zero string length", []Option{WithMaxStringLength(0)}},
+	} {
+		if _, err := newOptions(tt.opts); err == nil {
+			t.Errorf("newOptions() with %s succeeded, want an error", tt.name)
+		}
+	}
+	if _, err := New(context.Background(), WithMaxStringLength(0)); err == nil || !strings.Contains(err.Error(), "string length") {
+		t.Errorf("New() with a zero string length: %v, want the length error", err)
+	}
+}
+
+func TestMemoryPages(t *testing.T) {
+	ts := newTestInstance(t, WithMinMemoryPages(1024), WithMaxMemoryPages(1100))
+
+	if got, want := ts.memory.Size(), uint32(1024*wasmPageSize); got != want {
+		t.Errorf("initial memory = %d bytes, want %d", got, want)
+	}
+	if max, ok := ts.memory.Definition().Max(); !ok || max != 1100 {
+		t.Errorf("memory maximum = %d, %v, want 1100 pages", max, ok)
+	}
+
+	// An allocation that fits under the cap grows the memory up to it at most;
+	// one that does not fails without growing it past the cap.
+	ptr, err := ts.malloc(66 << 20)
+	if err != nil {
+		t.Fatalf("malloc() under the cap: %v", err)
+	}
+	if ts.memory.Size() <= 1024*wasmPageSize {
+		t.Errorf("memory did not grow for malloc() beyond the initial size")
+	}
+	ts.free(ptr)
+	if _, err := ts.malloc(96 << 20); !errors.Is(err, ErrNullPointer) {
+		t.Errorf("malloc() above the cap: %v, want ErrNullPointer", err)
+	}
+	if got, max := ts.memory.Size(), uint32(1100*wasmPageSize); got > max {
+		t.Errorf("memory grew to %d bytes, above the cap of %d", got, max)
+	}
+}
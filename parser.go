@@ -0,0 +1,635 @@
+package treesitter
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"sync/atomic"
+)
+
+// inputBufferSize is the size in bytes of the buffer ts_parser_new_wasm
+// allocates for tree_sitter_parse_callback. The core module reads at most
+// inputBufferSize-2 bytes per chunk.
+const inputBufferSize = 10 * 1024
+
+// maxChunkUnits is the number of UTF-16 code units written per input chunk.
+const maxChunkUnits = (inputBufferSize - 2) / 2
+
+// Parser wraps a TSParser.
+type Parser struct {
+	ts          *TreeSitter
+	pointer     uint32
+	inputBuffer uint32
+	language    *Language
+	logger      func(LogType, string)
+	// includedRanges are passed to every parse: ts_parser_parse_wasm
+	// replaces the parser's included ranges with the ones it is given,
+	// converted to code units of the text being parsed.
+	includedRanges []Range
+	// offsets converts the included ranges the module holds, which are in
+	// code units of the text last parsed, to bytes.
+	offsets *textOffsets
+	// cancellationFlag is checked while parsing, from the goroutine the
+	// parse runs on, and set by Cancel from any other.
+	cancellationFlag atomic.Pointer[uint32]
+}
+
+// LogType tells which part of the parser a log message comes from.
+type LogType int
+
+const (
+	// LogTypeParse messages describe the parser's actions, such as shifts,
+	// reductions and error recovery.
+	LogTypeParse LogType = iota
+	// LogTypeLex messages describe the lexer's progress through the input.
+	LogTypeLex
+)
+
+// NewParser creates a new parser with no language set.
+func (ts *TreeSitter) NewParser() (*Parser, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, err := ts.call("ts_parser_new_wasm"); err != nil {
+		return nil, err
+	}
+	pointer := ts.readTransfer(0)
+	if pointer == 0 {
+		return nil, fmt.Errorf("%w: ts_parser_new_wasm returned a null parser", ErrNullPointer)
+	}
+	p := &Parser{
+		ts:          ts,
+		pointer:     pointer,
+		inputBuffer: ts.readTransfer(1),
+	}
+	runtime.SetFinalizer(p, (*Parser).release)
+	return p, nil
+}
+
+// release frees a parser the garbage collector found unreachable.
+func (p *Parser) release() {
+	p.ts.scheduleRelease("ts_parser_delete", p.pointer)
+	p.ts.scheduleRelease("free", p.inputBuffer)
+}
+
+// checkDeleted returns ErrDeleted once the parser has been deleted. Every
+// call into the module that takes the parser's pointer checks it first,
+// since the pointer is 0 by then.
+func (p *Parser) checkDeleted() error {
+	if p.pointer == 0 {
+		return fmt.Errorf("%w: parser", ErrDeleted)
+	}
+	return nil
+}
+
+// SetLanguage assigns a language loaded with LoadLanguage to the parser. It
+// fails with ErrIncompatibleLanguageVersion if the grammar was generated for
+// an ABI version the core module does not support.
+func (p *Parser) SetLanguage(language *Language) error {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	if err := p.checkDeleted(); err != nil {
+		return err
+	}
+	if language.ts != p.ts {
+		return fmt.Errorf("language belongs to a different TreeSitter instance")
+	}
+	if !language.IsCompatible() {
+		return language.incompatibleError()
+	}
+	res, err := p.ts.call("ts_parser_set_language", uint64(p.pointer), uint64(language.pointer))
+	if err != nil {
+		return err
+	}
+	// The version is the only reason ts_parser_set_language refuses a
+	// language.
+	if uint32(res[0]) == 0 {
+		return language.incompatibleError()
+	}
+	p.language = language
+	return nil
+}
+
+// SetLanguageByName is like SetLanguage, but assigns the language registered
+// as name in the instance's Registry. An unknown name is reported as
+// ErrLanguageNotRegistered.
+func (p *Parser) SetLanguageByName(name string) error {
+	language, ok := p.ts.Registry().Get(name)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrLanguageNotRegistered, name)
+	}
+	return p.SetLanguage(language)
+}
+
+// Language returns the language assigned with SetLanguage, or nil if there
+// is none.
+//
+// The core module exports neither ts_parser_language nor ts_tree_language;
+// the parser keeps track of its language instead, and hands it on to the
+// trees it produces.
+func (p *Parser) Language() *Language {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	return p.language
+}
+
+// ParseString parses text and returns the resulting syntax tree.
+//
+// The core module always reads its input as UTF-16, so text is transcoded
+// as it reads it, and every offset and column reported for the tree is
+// converted back to a byte offset into text. Invalid UTF-8 is read as
+// U+FFFD, one for each invalid byte, which keeps the offsets around it
+// exact.
+func (p *Parser) ParseString(text string) (*Tree, error) {
+	return p.ParseStringContext(p.ts.ctx, text)
+}
+
+// ParseStringContext is like ParseString, but abandons the parse once ctx is
+// done, returning an error that wraps both ErrParseCancelled and ctx.Err().
+// As with a timeout, the abandoned parse is resumed by the next parse unless
+// Reset is called.
+func (p *Parser) ParseStringContext(ctx context.Context, text string) (*Tree, error) {
+	return p.parse(ctx, nil, stringInput(p.ts, text))
+}
+
+// ParseStringRange parses text[start:end] as a complete document, as
+// ParseString would parse that slice. Offsets and points in the tree are
+// relative to start. Only the slice is handed to the core module, and
+// slicing text does not copy it.
+//
+// It is an error for start to be after end or for end to be past the end of
+// text.
+func (p *Parser) ParseStringRange(text string, start, end uint32) (*Tree, error) {
+	if start > end || int(end) > len(text) {
+		return nil, fmt.Errorf("invalid range [%d, %d) of text with %d bytes", start, end, len(text))
+	}
+	return p.ParseString(text[start:end])
+}
+
+// ParseStringWithOldTree parses text incrementally, reusing the unchanged
+// parts of old, which must be a tree for the previous version of the text.
+//
+// Before the call, old must have been passed to Tree.Edit for every change
+// made to the text since it was parsed; otherwise the new tree will be
+// wrong. old stays valid and may be deleted once the new tree has been
+// produced.
+func (p *Parser) ParseStringWithOldTree(old *Tree, text string) (*Tree, error) {
+	return p.parse(p.ts.ctx, old, stringInput(p.ts, text))
+}
+
+// ParseStringInto is like ParseStringWithOldTree, but also deletes old once
+// the new tree has been produced, in the same call, so that an editor can
+// reparse on every keystroke with one call and a single live tree:
+//
+//	tree, err = parser.ParseStringInto(tree, text)
+//
+// old must have been edited with Tree.Edit as for ParseStringWithOldTree,
+// or be nil for the first parse. If the parse fails, old is left as it was
+// and may be used or deleted as before. Once the parse has succeeded, old is
+// deleted whatever happens: should freeing it fail, the new tree is returned
+// along with the error.
+func (p *Parser) ParseStringInto(old *Tree, text string) (*Tree, error) {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	tree, err := p.parseLocked(p.ts.ctx, old, stringInput(p.ts, text))
+	if err != nil || old == nil {
+		return tree, err
+	}
+	return tree, old.delete()
+}
+
+// ParseReader parses the first length bytes of r. Unlike ParseString, it
+// never holds the whole input in memory: the core module asks for the text a
+// chunk at a time and each chunk is read from r as it is needed.
+//
+// As with ParseString, the text is transcoded to UTF-16 and offsets in the
+// tree are byte offsets into r. Converting them needs to know where the
+// multibyte characters are, so r is read through once before the parse and
+// again, a chunk at a time, during it. It is an error for r to hold fewer
+// than length bytes.
+func (p *Parser) ParseReader(r io.ReaderAt, length uint32) (*Tree, error) {
+	offsets, ascii, err := scanReader(r, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	var readErr error
+	chunk := make([]byte, maxChunkUnits)
+	tree, err := p.parse(p.ts.ctx, nil, textInput{offsets: offsets, read: func(buffer, index uint32) uint32 {
+		start := offsets.byteOffset(index)
+		if readErr != nil || start >= length {
+			return 0
+		}
+		want := chunk[:min(maxChunkUnits, length-start)]
+		if err := readFull(r, want, start); err != nil {
+			readErr = err
+			return 0
+		}
+		if ascii {
+			return p.ts.writeBytesAsUnits(buffer, want)
+		}
+		return p.ts.writeUTF16(buffer, string(want), start+uint32(len(want)) == length)
+	}})
+	if readErr != nil {
+		if tree != nil {
+			tree.Delete()
+		}
+		return nil, fmt.Errorf("failed to read input: %w", readErr)
+	}
+	return tree, err
+}
+
+// scanReader reads the first length bytes of r through and returns their
+// offsets, reporting whether they are all ASCII.
+func scanReader(r io.ReaderAt, length uint32) (*textOffsets, bool, error) {
+	var b offsetsBuilder
+	chunk := make([]byte, maxChunkUnits)
+	for offset := uint32(0); offset < length; {
+		want := chunk[:min(maxChunkUnits, length-offset)]
+		if err := readFull(r, want, offset); err != nil {
+			return nil, false, err
+		}
+		offset += uint32(b.add(string(want), offset+uint32(len(want)) == length))
+	}
+	offsets, ascii := b.finish()
+	return offsets, ascii, nil
+}
+
+// readFull fills buf from r at offset, reporting a short read as
+// io.ErrUnexpectedEOF.
+func readFull(r io.ReaderAt, buf []byte, offset uint32) error {
+	n, err := r.ReadAt(buf, int64(offset))
+	if n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// ParseUTF16 parses text given as UTF-16 code units, such as an editor
+// buffer or an LSP document, without transcoding it to UTF-8 first.
+//
+// The code units are handed to the core module as they are, so offsets in
+// the resulting tree are not byte offsets: StartByte, EndByte and the
+// columns of points all count UTF-16 code units from the start of data, and
+// a character outside the Basic Multilingual Plane counts as two. Node.Text
+// does not apply to such a tree; slice data with the offsets instead.
+func (p *Parser) ParseUTF16(data []uint16) (*Tree, error) {
+	return p.parse(p.ts.ctx, nil, textInput{read: func(buffer, index uint32) uint32 {
+		if index >= uint32(len(data)) {
+			return 0
+		}
+		end := min(uint32(len(data)), index+maxChunkUnits)
+		chunk := make([]byte, 0, 2*(end-index))
+		for _, unit := range data[index:end] {
+			chunk = binary.LittleEndian.AppendUint16(chunk, unit)
+		}
+		p.ts.memory.Write(buffer, chunk)
+		return end - index
+	}})
+}
+
+// textInput is the text a parse reads. read writes the chunk of text
+// starting at the code unit index into buffer and returns its length in code
+// units; see parseCallback. offsets converts between the text's bytes and
+// code units.
+type textInput struct {
+	read    func(buffer, index uint32) uint32
+	offsets *textOffsets
+}
+
+// stringInput returns the input that feeds text to the core module.
+func stringInput(ts *TreeSitter, text string) textInput {
+	offsets, ascii := scanText(text)
+	return textInput{offsets: offsets, read: func(buffer, index uint32) uint32 {
+		start := offsets.byteOffset(index)
+		if start >= uint32(len(text)) {
+			return 0
+		}
+		if ascii {
+			end := min(uint32(len(text)), start+maxChunkUnits)
+			return ts.writeBytesAsUnits(buffer, []byte(text[start:end]))
+		}
+		return ts.writeUTF16(buffer, text[start:], true)
+	}}
+}
+
+// parse runs ts_parser_parse_wasm, reading the text from input and
+// optionally reusing old.
+func (p *Parser) parse(ctx context.Context, old *Tree, input textInput) (*Tree, error) {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	return p.parseLocked(ctx, old, input)
+}
+
+// parseLocked implements parse for callers already holding the lock.
+func (p *Parser) parseLocked(ctx context.Context, old *Tree, input textInput) (*Tree, error) {
+	if err := p.checkDeleted(); err != nil {
+		return nil, err
+	}
+	p.ts.input = input.read
+	p.ts.logger = p.logger
+	p.ts.cancellationFlag = p.cancellationFlag.Load()
+	defer func() { p.ts.input, p.ts.logger, p.ts.cancellationFlag = nil, nil, nil }()
+
+	var oldPointer uint32
+	if old != nil {
+		if err := old.checkDeleted(); err != nil {
+			return nil, err
+		}
+		if err := old.matchText(input.offsets); err != nil {
+			return nil, err
+		}
+		oldPointer = old.pointer
+	}
+	// ts_parser_parse_wasm frees the ranges array itself.
+	var rangesPointer uint32
+	if len(p.includedRanges) > 0 {
+		var err error
+		rangesPointer, err = p.ts.malloc(uint32(len(p.includedRanges)) * rangeSize)
+		if err != nil {
+			return nil, err
+		}
+		units := make([]Range, len(p.includedRanges))
+		for i, r := range p.includedRanges {
+			units[i] = positions{text: input.offsets}.unitRange(r)
+		}
+		if err := p.ts.writeRanges(rangesPointer, units); err != nil {
+			p.ts.free(rangesPointer)
+			return nil, err
+		}
+	}
+	res, err := p.ts.callContext(ctx, "ts_parser_parse_wasm", uint64(p.pointer), uint64(p.inputBuffer), uint64(oldPointer), uint64(rangesPointer), uint64(len(p.includedRanges)))
+	if err != nil {
+		return nil, err
+	}
+	pointer := uint32(res[0])
+	if pointer == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrParseCancelled, err)
+		}
+		if flag := p.ts.cancellationFlag; flag != nil && atomic.LoadUint32(flag) != 0 {
+			return nil, ErrParseCancelled
+		}
+		return nil, p.nullTreeError()
+	}
+	p.offsets = input.offsets
+	tree := p.ts.newTree(pointer, p.language)
+	tree.offsets = input.offsets
+	return tree, nil
+}
+
+// nullTreeError explains why ts_parser_parse_wasm returned no tree.
+func (p *Parser) nullTreeError() error {
+	if p.language != nil {
+		timeout, err := p.timeout()
+		if err != nil {
+			return err
+		}
+		if timeout > 0 {
+			return ErrParseTimeout
+		}
+	}
+	return fmt.Errorf("%w: null tree returned", ErrParseFailed)
+}
+
+// SetIncludedRanges restricts later parses to the given ranges of the text,
+// such as the contents of the <script> elements of an HTML document. The
+// text between the ranges is skipped, but positions in the tree are still
+// offsets into the whole text. The ranges must be in order and must not
+// overlap. With no ranges, the whole text is parsed again.
+func (p *Parser) SetIncludedRanges(ranges []Range) error {
+	var previousEnd uint32
+	for i, r := range ranges {
+		if r.StartByte < previousEnd || r.EndByte < r.StartByte {
+			return fmt.Errorf("included range %d [%d, %d) is out of order or overlaps the one before", i, r.StartByte, r.EndByte)
+		}
+		previousEnd = r.EndByte
+	}
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	if err := p.checkDeleted(); err != nil {
+		return err
+	}
+	// ts_parser_set_included_ranges is the C function, which takes the
+	// offsets and columns the core module uses internally, twice the code
+	// units, and copies the array.
+	var rangesPointer uint32
+	if len(ranges) > 0 {
+		var err error
+		rangesPointer, err = p.ts.malloc(uint32(len(ranges)) * rangeSize)
+		if err != nil {
+			return err
+		}
+		defer p.ts.free(rangesPointer)
+		internal := make([]Range, len(ranges))
+		for i, r := range ranges {
+			internal[i] = internalRange(positions{text: p.offsets}.unitRange(r))
+		}
+		if err := p.ts.writeRanges(rangesPointer, internal); err != nil {
+			return err
+		}
+	}
+	res, err := p.ts.call("ts_parser_set_included_ranges", uint64(p.pointer), uint64(rangesPointer), uint64(len(ranges)))
+	if err != nil {
+		return err
+	}
+	if uint32(res[0]) == 0 {
+		return fmt.Errorf("included ranges rejected by the parser")
+	}
+	p.includedRanges = slices.Clone(ranges)
+	return nil
+}
+
+// internalRange converts r from code units to the offsets and columns the
+// core module uses internally, saturating as unitsToBytes does.
+func internalRange(r Range) Range {
+	double := func(x uint32) uint32 { return uint32(unitsToBytes(x)) }
+	return Range{
+		StartByte:  double(r.StartByte),
+		EndByte:    double(r.EndByte),
+		StartPoint: Point{Row: r.StartPoint.Row, Column: double(r.StartPoint.Column)},
+		EndPoint:   Point{Row: r.EndPoint.Row, Column: double(r.EndPoint.Column)},
+	}
+}
+
+// IncludedRanges returns the ranges the parser reads, as the core module
+// reports them: those set with SetIncludedRanges, or passed to the last
+// parse, normalized by the parser. A parser without included ranges parses
+// the whole text, reported as a single range that starts at 0 and ends past
+// any real offset and point, as for Tree.IncludedRanges.
+func (p *Parser) IncludedRanges() ([]Range, error) {
+	ts := p.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := p.checkDeleted(); err != nil {
+		return nil, err
+	}
+	if _, err := ts.call("ts_parser_included_ranges_wasm", uint64(p.pointer)); err != nil {
+		return nil, err
+	}
+	count := ts.readTransfer(0)
+	address := ts.readTransfer(1)
+	if address == 0 {
+		return nil, nil
+	}
+	defer ts.free(address)
+	ranges, err := ts.readRanges(address, count)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range ranges {
+		ranges[i] = positions{text: p.offsets}.byteRange(r)
+	}
+	return ranges, nil
+}
+
+// SetLogger makes the parser pass its log messages to fn while it parses.
+// Tree-sitter logs every lexing and parsing step, so this slows parsing down
+// considerably; it is meant for debugging grammars. A nil fn, the default,
+// turns logging off.
+//
+// fn runs on the goroutine doing the parse, in the middle of it, while the
+// instance's lock is held. It must not call any method of the parser, of
+// its trees, or of anything else created from the same TreeSitter instance:
+// the lock is not reentrant, so such a call deadlocks. Record the messages
+// instead, and act on them once the parse has returned.
+func (p *Parser) SetLogger(fn func(logType LogType, message string)) error {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	if err := p.checkDeleted(); err != nil {
+		return err
+	}
+	enable := uint64(0)
+	if fn != nil {
+		enable = 1
+	}
+	if _, err := p.ts.call("ts_parser_enable_logger_wasm", uint64(p.pointer), enable); err != nil {
+		return err
+	}
+	p.logger = fn
+	return nil
+}
+
+// PrintDotGraphs would make later parses write the parser's stack to w as a
+// DOT graph at every step. The core module does not export
+// ts_parser_print_dot_graphs, so it always returns ErrUnsupported. A logger
+// set with SetLogger traces the same steps as text, and Tree.PrintDotGraph
+// draws the tree that results.
+func (p *Parser) PrintDotGraphs(w io.Writer) error {
+	return fmt.Errorf("%w: DOT graphs need ts_parser_print_dot_graphs", ErrUnsupported)
+}
+
+// StopPrintingDotGraphs undoes PrintDotGraphs. As PrintDotGraphs never
+// succeeds, there is nothing to stop.
+func (p *Parser) StopPrintingDotGraphs() {}
+
+// SetCancellationFlag makes later parses check flag as they go and abandon
+// the parse with ErrParseCancelled once it is nonzero. The flag may be set
+// from any goroutine with atomic.StoreUint32 or Cancel. It is not cleared
+// when a parse is cancelled: store 0 in it before parsing again. A nil flag
+// removes it.
+//
+// As with a timeout, the abandoned parse is resumed by the next parse unless
+// Reset is called.
+func (p *Parser) SetCancellationFlag(flag *uint32) error {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	if err := p.checkDeleted(); err != nil {
+		return err
+	}
+	p.cancellationFlag.Store(flag)
+	return nil
+}
+
+// Cancel sets the cancellation flag given to SetCancellationFlag, abandoning
+// the parse in progress, if any. Unlike the parser's other methods, it does
+// not wait for the parse to finish and may be called from any goroutine.
+func (p *Parser) Cancel() error {
+	flag := p.cancellationFlag.Load()
+	if flag == nil {
+		return fmt.Errorf("parser has no cancellation flag")
+	}
+	atomic.StoreUint32(flag, 1)
+	return nil
+}
+
+// SetTimeout sets the maximum time, in microseconds, a parse may take before
+// it is abandoned with ErrParseTimeout. Zero, the default, means no limit.
+//
+// An abandoned parse is not discarded: the next parse resumes it, so it must
+// be given the same text. Call Reset to parse something else instead.
+func (p *Parser) SetTimeout(micros uint64) error {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	if err := p.checkDeleted(); err != nil {
+		return err
+	}
+	_, err := p.ts.call("ts_parser_set_timeout_micros", uint64(p.pointer), micros)
+	return err
+}
+
+// Timeout returns the parser's timeout in microseconds.
+func (p *Parser) Timeout() (uint64, error) {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	return p.timeout()
+}
+
+// timeout implements Timeout for callers already holding the lock.
+func (p *Parser) timeout() (uint64, error) {
+	if err := p.checkDeleted(); err != nil {
+		return 0, err
+	}
+	res, err := p.ts.call("ts_parser_timeout_micros", uint64(p.pointer))
+	if err != nil {
+		return 0, err
+	}
+	return res[0], nil
+}
+
+// Reset discards the parse left unfinished by a timeout or cancellation, so
+// that the next parse starts from the beginning of its text.
+func (p *Parser) Reset() error {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	if err := p.checkDeleted(); err != nil {
+		return err
+	}
+	_, err := p.ts.call("ts_parser_reset", uint64(p.pointer))
+	return err
+}
+
+// Delete frees the parser and its input buffer. A parser that is never
+// deleted is freed once the garbage collector finds it unreachable, but
+// Delete releases the memory deterministically and is preferred. Deleting a
+// parser again does nothing.
+func (p *Parser) Delete() error {
+	p.ts.mu.Lock()
+	defer p.ts.mu.Unlock()
+	if p.pointer == 0 {
+		return nil
+	}
+	runtime.SetFinalizer(p, nil)
+	pointer, inputBuffer := p.pointer, p.inputBuffer
+	p.pointer, p.inputBuffer = 0, 0
+	if _, err := p.ts.call("ts_parser_delete", uint64(pointer)); err != nil {
+		return err
+	}
+	return p.ts.free(inputBuffer)
+}
+
+// writeBytesAsUnits writes chunk, which must be ASCII, into buffer, widening
+// each byte to a little-endian UTF-16 code unit, and returns how many were
+// written. chunk must be at most maxChunkUnits long.
+func (ts *TreeSitter) writeBytesAsUnits(buffer uint32, chunk []byte) uint32 {
+	units := make([]byte, 2*len(chunk))
+	for i, b := range chunk {
+		units[2*i] = b
+	}
+	ts.memory.Write(buffer, units)
+	return uint32(len(chunk))
+}
@@ -0,0 +1,517 @@
+package treesitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unicode/utf16"
+)
+
+// largeJSON returns a JSON array of n numbers.
+func largeJSON(n int) string {
+	return "[" + strings.Repeat("1, ", n-1) + "1]"
+}
+
+func TestSetTimeout(t *testing.T) {
+	p := newTestParser(t, "json")
+	if err := p.SetTimeout(1); err != nil {
+		t.Fatal(err)
+	}
+	if micros, err := p.Timeout(); err != nil || micros != 1 {
+		t.Errorf("Timeout() = %d, %v, want 1", micros, err)
+	}
+	text := largeJSON(100000)
+	if _, err := p.ParseString(text); !errors.Is(err, ErrParseTimeout) {
+		t.Errorf("ParseString() with a 1µs timeout: %v, want ErrParseTimeout", err)
+	}
+
+	if err := p.SetTimeout(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	parseTest(t, p, text)
+}
+
+func TestConcurrentParse(t *testing.T) {
+	ts := newTestInstance(t)
+	language := loadTestLanguage(t, ts, "json")
+	const text = `{"a": [1, 2, {"b": null}]}`
+	const want = `(document (object (pair key: (string (string_content)) value: (array (number) (number) (object (pair key: (string (string_content)) value: (null)))))))`
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := ts.NewParser()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer p.Delete()
+			if err := p.SetLanguage(language); err != nil {
+				errs <- err
+				return
+			}
+			for range 20 {
+				tree, err := p.ParseString(text)
+				if err != nil {
+					errs <- err
+					return
+				}
+				root, err := tree.RootNode()
+				if err != nil {
+					errs <- err
+					return
+				}
+				s, err := root.String()
+				tree.Delete()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if s != want {
+					errs <- fmt.Errorf("tree = %s, want %s", s, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// nodeRanges returns the type and range of n and each of its descendants, in
+// document order.
+func nodeRanges(t testing.TB, n *Node) []string {
+	t.Helper()
+	r, err := n.Range()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := []string{fmt.Sprintf("%s %v", nodeType(t, n), r)}
+	children, err := n.Children()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, child := range children {
+		ranges = append(ranges, nodeRanges(t, child)...)
+	}
+	return ranges
+}
+
+func TestParseReader(t *testing.T) {
+	p := newTestParser(t, "go")
+	// Enough lines for the input to be read in several chunks, with
+	// multibyte characters straddling some of the chunk boundaries.
+	var b strings.Builder
+	b.WriteString("package main\n")
+	for range 500 {
+		b.WriteString("var ßΩ = \"😀€ü x\" // 日本\n")
+	}
+	text := b.String()
+
+	_, want := parseTest(t, p, text)
+	tree, err := p.ParseReader(strings.NewReader(text), uint32(len(text)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := mustRoot(t, tree)
+	if end, err := got.EndByte(); err != nil || end != uint32(len(text)) {
+		t.Errorf("EndByte() = %d, %v, want %d", end, err, len(text))
+	}
+	if g, w := nodeRanges(t, got), nodeRanges(t, want); !slices.Equal(g, w) {
+		t.Errorf("ParseReader() tree differs from ParseString():\n%s\nwant\n%s", strings.Join(g[:10], "\n"), strings.Join(w[:10], "\n"))
+	}
+
+	if _, err := p.ParseReader(strings.NewReader(text), uint32(len(text))+1); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ParseReader() past the end of the reader: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestParseUTF16(t *testing.T) {
+	p := newTestParser(t, "json")
+	text := `["😀", "x"]`
+	tree, err := p.ParseUTF16(utf16.Encode([]rune(text)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := mustRoot(t, tree)
+	if s := nodeString(t, root); s != "(document (array (string (string_content)) (string (string_content))))" {
+		t.Errorf("tree = %s", s)
+	}
+	// "😀" is one character, two code units and four bytes.
+	if end, err := root.EndByte(); err != nil || end != 11 {
+		t.Errorf("EndByte() = %d, %v, want 11 code units", end, err)
+	}
+	second, err := root.DescendantForByteRange(7, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := nodeType(t, second); typ != `"` || startByte(t, second) != 7 {
+		t.Errorf("node at code unit 7 = %q at %d, want the second string's quote", typ, startByte(t, second))
+	}
+
+	// The same text as UTF-8 is measured in bytes.
+	_, root = parseTest(t, p, text)
+	if end, err := root.EndByte(); err != nil || end != uint32(len(text)) {
+		t.Errorf("EndByte() of the UTF-8 parse = %d, %v, want %d bytes", end, err, len(text))
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	p := newTestParser(t, "json")
+	var parse, lex []string
+	if err := p.SetLogger(func(logType LogType, message string) {
+		switch logType {
+		case LogTypeParse:
+			parse = append(parse, message)
+		case LogTypeLex:
+			lex = append(lex, message)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	parseTest(t, p, `[1]`)
+	if len(parse) == 0 || len(lex) == 0 {
+		t.Fatalf("logged %d parse and %d lex messages, want some of each", len(parse), len(lex))
+	}
+	if !slices.ContainsFunc(parse, func(m string) bool { return strings.HasPrefix(m, "shift") }) {
+		t.Errorf("parse messages do not include a shift: %q", parse)
+	}
+
+	if err := p.SetLogger(nil); err != nil {
+		t.Fatal(err)
+	}
+	parse, lex = nil, nil
+	parseTest(t, p, `[1]`)
+	if len(parse) != 0 || len(lex) != 0 {
+		t.Errorf("logged %d messages after SetLogger(nil), want none", len(parse)+len(lex))
+	}
+}
+
+func TestSetIncludedRanges(t *testing.T) {
+	p := newTestParser(t, "json")
+	// The text between the ranges, with its two-byte characters, is not
+	// JSON; the offsets are still bytes into the whole text.
+	text := `[1] é é [2]`
+	ranges := []Range{
+		{StartByte: 0, EndByte: 3, StartPoint: Point{Row: 0, Column: 0}, EndPoint: Point{Row: 0, Column: 3}},
+		{StartByte: 10, EndByte: 13, StartPoint: Point{Row: 0, Column: 10}, EndPoint: Point{Row: 0, Column: 13}},
+	}
+	if err := p.SetIncludedRanges(ranges); err != nil {
+		t.Fatal(err)
+	}
+	tree, root := parseTest(t, p, text)
+	if s := nodeString(t, root); s != "(document (array (number)) (array (number)))" {
+		t.Errorf("tree = %s", s)
+	}
+	second, err := root.Child(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := second.Range(); err != nil || r != ranges[1] {
+		t.Errorf("second array's Range() = %v, %v, want %v", r, err, ranges[1])
+	}
+
+	if got, err := p.IncludedRanges(); err != nil || !slices.Equal(got, ranges) {
+		t.Errorf("Parser.IncludedRanges() = %v, %v, want %v", got, err, ranges)
+	}
+	if got, err := tree.IncludedRanges(); err != nil || !slices.Equal(got, ranges) {
+		t.Errorf("Tree.IncludedRanges() = %v, %v, want %v", got, err, ranges)
+	}
+
+	if err := p.SetIncludedRanges([]Range{ranges[1], ranges[0]}); err == nil {
+		t.Error("SetIncludedRanges() of ranges out of order succeeded")
+	}
+	if err := p.SetIncludedRanges(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.IncludedRanges(); err != nil || len(got) != 1 || got[0].StartByte != 0 || got[0].EndByte < uint32(len(text)) {
+		t.Errorf("IncludedRanges() after clearing them = %v, %v, want the whole text", got, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	p := newTestParser(t, "json")
+	if err := p.Cancel(); err == nil {
+		t.Error("Cancel() without a cancellation flag succeeded")
+	}
+	var flag uint32
+	if err := p.SetCancellationFlag(&flag); err != nil {
+		t.Fatal(err)
+	}
+	// The logger holds the parse at its first message until Cancel has
+	// been called from the test goroutine.
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	if err := p.SetLogger(func(LogType, string) {
+		once.Do(func() {
+			close(started)
+			<-cancelled
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := p.ParseString(largeJSON(10000))
+		errc <- err
+	}()
+	<-started
+	if err := p.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	close(cancelled)
+	if err := <-errc; !errors.Is(err, ErrParseCancelled) {
+		t.Errorf("ParseString() cancelled from another goroutine: %v, want ErrParseCancelled", err)
+	}
+	if atomic.LoadUint32(&flag) != 1 {
+		t.Error("Cancel() did not set the flag")
+	}
+}
+
+func TestResetAfterCancel(t *testing.T) {
+	p := newTestParser(t, "json")
+	var flag uint32
+	if err := p.SetCancellationFlag(&flag); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	text := largeJSON(100000)
+	if _, err := p.ParseString(text); !errors.Is(err, ErrParseCancelled) {
+		t.Fatalf("ParseString() with the flag set: %v, want ErrParseCancelled", err)
+	}
+	atomic.StoreUint32(&flag, 0)
+	if err := p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	_, root := parseTest(t, p, `{"a": 1}`)
+	if s := nodeString(t, root); s != "(document (object (pair key: (string (string_content)) value: (number))))" {
+		t.Errorf("tree after Reset = %s", s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.ParseStringContext(ctx, text); !errors.Is(err, ErrParseCancelled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("ParseStringContext() with a cancelled context: %v, want ErrParseCancelled and context.Canceled", err)
+	}
+	if err := p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	_, root = parseTest(t, p, `[true]`)
+	if s := nodeString(t, root); s != "(document (array (true)))" {
+		t.Errorf("tree after Reset = %s", s)
+	}
+}
+
+func TestSetLanguageByName(t *testing.T) {
+	ts := newTestInstance(t)
+	if err := ts.Registry().RegisterFile("json", "testdata/tree-sitter-json.wasm"); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ts.NewParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLanguageByName("json"); err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := ts.Registry().Get("json"); p.Language() != want {
+		t.Error("Language() is not the registered language")
+	}
+	if _, root := parseTest(t, p, `[1]`); nodeType(t, root) != "document" {
+		t.Errorf("root node type = %s, want document", nodeType(t, root))
+	}
+
+	if err := p.SetLanguageByName("go"); !errors.Is(err, ErrLanguageNotRegistered) {
+		t.Errorf("SetLanguageByName() of an unregistered name: %v, want ErrLanguageNotRegistered", err)
+	}
+	// A separate registry does not feed SetLanguageByName.
+	if err := ts.NewLanguageRegistry().RegisterFile("go", "testdata/tree-sitter-go.wasm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLanguageByName("go"); !errors.Is(err, ErrLanguageNotRegistered) {
+		t.Errorf("SetLanguageByName() of a name in another registry: %v, want ErrLanguageNotRegistered", err)
+	}
+}
+
+// typeAt returns the edit that inserts s at offset into a tree of text, which
+// must be a single line.
+func typeAt(offset uint32, s string) InputEdit {
+	end := offset + uint32(len(s))
+	return InputEdit{
+		StartByte:   offset,
+		OldEndByte:  offset,
+		NewEndByte:  end,
+		StartPoint:  Point{Column: offset},
+		OldEndPoint: Point{Column: offset},
+		NewEndPoint: Point{Column: end},
+	}
+}
+
+func TestParseStringInto(t *testing.T) {
+	p := newTestParser(t, "json")
+	tree, err := p.ParseStringInto(nil, `[1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseline := p.ts.MemStats().LiveAllocations
+
+	old := tree
+	if err := old.Edit(typeAt(2, ", 2")); err != nil {
+		t.Fatal(err)
+	}
+	if tree, err = p.ParseStringInto(old, `[1, 2]`); err != nil {
+		t.Fatal(err)
+	}
+	if s := nodeString(t, mustRoot(t, tree)); s != "(document (array (number) (number)))" {
+		t.Errorf("tree = %s", s)
+	}
+	if _, err := old.RootNode(); !errors.Is(err, ErrDeleted) {
+		t.Errorf("RootNode() of the old tree: %v, want ErrDeleted", err)
+	}
+	if live := p.ts.MemStats().LiveAllocations; live != baseline {
+		t.Errorf("LiveAllocations = %d after reparsing into the old tree, want %d", live, baseline)
+	}
+
+	// A failed parse leaves the old tree alone.
+	var flag uint32 = 1
+	if err := p.SetCancellationFlag(&flag); err != nil {
+		t.Fatal(err)
+	}
+	more := strings.Repeat(", 3", 100000)
+	if err := tree.Edit(typeAt(5, more)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ParseStringInto(tree, `[1, 2`+more+`]`); !errors.Is(err, ErrParseCancelled) {
+		t.Fatalf("ParseStringInto() with the flag set: %v, want ErrParseCancelled", err)
+	}
+	if _, err := tree.RootNode(); err != nil {
+		t.Errorf("RootNode() of the old tree after a failed parse: %v", err)
+	}
+}
+
+// BenchmarkParseStringInto simulates typing a JSON array one keystroke at a
+// time, reparsing into the previous tree after each.
+func BenchmarkParseStringInto(b *testing.B) {
+	p := newTestParser(b, "json")
+	for b.Loop() {
+		text := "[]"
+		tree, err := p.ParseStringInto(nil, text)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := range 200 {
+			key := ", "
+			if i%3 == 0 {
+				key = "1"
+			}
+			offset := uint32(len(text) - 1)
+			text = text[:offset] + key + text[offset:]
+			if err := tree.Edit(typeAt(offset, key)); err != nil {
+				b.Fatal(err)
+			}
+			if tree, err = p.ParseStringInto(tree, text); err != nil {
+				b.Fatal(err)
+			}
+		}
+		tree.Delete()
+	}
+}
+
+func TestParseStringRange(t *testing.T) {
+	p := newTestParser(t, "json")
+	// A JSON value embedded in other text.
+	text := "let v = {\"é\": [1, 2]}; // done"
+	start, end := uint32(8), uint32(22)
+	tree, err := p.ParseStringRange(text, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, direct := parseTest(t, p, text[start:end])
+	got, want := nodeRanges(t, mustRoot(t, tree)), nodeRanges(t, direct)
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseStringRange() = %q, want the tree of the slice %q", got, want)
+	}
+	if s := nodeString(t, mustRoot(t, tree)); s != "(document (object (pair key: (string (string_content)) value: (array (number) (number)))))" {
+		t.Errorf("tree = %s", s)
+	}
+
+	for _, r := range [][2]uint32{{9, 8}, {0, uint32(len(text)) + 1}} {
+		if _, err := p.ParseStringRange(text, r[0], r[1]); err == nil {
+			t.Errorf("ParseStringRange(%d, %d) of %d bytes succeeded", r[0], r[1], len(text))
+		}
+	}
+}
+
+func TestPrintDotGraphs(t *testing.T) {
+	p := newTestParser(t, "json")
+	if p.ts.HasFunction("ts_parser_print_dot_graphs") {
+		t.Fatal("the core module exports ts_parser_print_dot_graphs, which PrintDotGraphs does not use")
+	}
+	var b strings.Builder
+	if err := p.PrintDotGraphs(&b); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("PrintDotGraphs() = %v, want ErrUnsupported", err)
+	}
+	p.StopPrintingDotGraphs()
+	parseTest(t, p, `[1]`)
+	if b.Len() != 0 {
+		t.Errorf("parse wrote %q to the writer given to PrintDotGraphs", b.String())
+	}
+}
+
+func TestParserDelete(t *testing.T) {
+	p := newTestParser(t, "json")
+	language := p.Language()
+	tree, _ := parseTest(t, p, `[1]`)
+	if err := p.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Delete(); err != nil {
+		t.Errorf("second Delete(): %v", err)
+	}
+
+	var flag uint32
+	for name, err := range map[string]error{
+		"SetLanguage":            p.SetLanguage(language),
+		"ParseString":            func() error { _, err := p.ParseString(`[1]`); return err }(),
+		"ParseStringWithOldTree": func() error { _, err := p.ParseStringWithOldTree(tree, `[1]`); return err }(),
+		"ParseStringInto":        func() error { _, err := p.ParseStringInto(tree, `[1]`); return err }(),
+		"ParseReader": func() error {
+			_, err := p.ParseReader(strings.NewReader(`[1]`), 3)
+			return err
+		}(),
+		"ParseUTF16":          func() error { _, err := p.ParseUTF16(utf16.Encode([]rune(`[1]`))); return err }(),
+		"SetIncludedRanges":   p.SetIncludedRanges(nil),
+		"IncludedRanges":      func() error { _, err := p.IncludedRanges(); return err }(),
+		"SetLogger":           p.SetLogger(func(LogType, string) {}),
+		"SetCancellationFlag": p.SetCancellationFlag(&flag),
+		"SetTimeout":          p.SetTimeout(12345),
+		"Timeout":             func() error { _, err := p.Timeout(); return err }(),
+		"Reset":               p.Reset(),
+	} {
+		if !errors.Is(err, ErrDeleted) {
+			t.Errorf("%s after Delete: %v, want ErrDeleted", name, err)
+		}
+	}
+	// The tree outlives its parser.
+	if s := nodeString(t, mustRoot(t, tree)); s != "(document (array (number)))" {
+		t.Errorf("tree after the parser's Delete = %s", s)
+	}
+}
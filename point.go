@@ -0,0 +1,168 @@
+package treesitter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Point is a position in the source text.
+//
+// Row is zero-based. Column is the number of bytes from the start of the
+// line, per tree-sitter convention, not a count of runes or UTF-16 units;
+// PointToUTF16 and UTF16ToByte convert to and from the UTF-16 columns of the
+// Language Server Protocol.
+type Point struct {
+	Row    uint32
+	Column uint32
+}
+
+// readPoint decodes the point the core module left in the transfer buffer.
+func (ts *TreeSitter) readPoint() Point {
+	return Point{Row: ts.readTransfer(0), Column: ts.readTransfer(1)}
+}
+
+// writePoint stores p in the transfer buffer at slots i and i+1.
+func (ts *TreeSitter) writePoint(i uint32, p Point) {
+	ts.writeTransfer(i, p.Row)
+	ts.writeTransfer(i+1, p.Column)
+}
+
+// pointAdd returns the point reached by moving by b from a, as tree-sitter's
+// point_add does: b's column counts from the start of a line if b crosses
+// one.
+func pointAdd(a, b Point) Point {
+	if b.Row > 0 {
+		return Point{Row: a.Row + b.Row, Column: b.Column}
+	}
+	return Point{Row: a.Row, Column: a.Column + b.Column}
+}
+
+// pointSub returns the distance from b to a, as tree-sitter's point_sub
+// does, the inverse of pointAdd.
+func pointSub(a, b Point) Point {
+	if a.Row > b.Row {
+		return Point{Row: a.Row - b.Row, Column: a.Column}
+	}
+	return Point{Row: 0, Column: a.Column - b.Column}
+}
+
+// Range is a span of the source text, given both as byte offsets and as
+// points.
+type Range struct {
+	StartByte  uint32
+	EndByte    uint32
+	StartPoint Point
+	EndPoint   Point
+}
+
+// rangeSize is sizeof(TSRange): two points followed by two byte offsets.
+const rangeSize = 24
+
+// readRanges decodes count TSRange structs stored at address. The *_wasm
+// functions that return range arrays have already converted the offsets and
+// columns to code units; Tree.readRanges converts them on to bytes.
+func (ts *TreeSitter) readRanges(address, count uint32) ([]Range, error) {
+	buf, ok := ts.memory.Read(address, count*rangeSize)
+	if !ok {
+		return nil, fmt.Errorf("%w: ranges at %d out of range", ErrMemoryRead, address)
+	}
+	ranges := make([]Range, count)
+	for i := range ranges {
+		b := buf[i*rangeSize:]
+		ranges[i] = Range{
+			StartPoint: Point{Row: binary.LittleEndian.Uint32(b[0:]), Column: binary.LittleEndian.Uint32(b[4:])},
+			EndPoint:   Point{Row: binary.LittleEndian.Uint32(b[8:]), Column: binary.LittleEndian.Uint32(b[12:])},
+			StartByte:  binary.LittleEndian.Uint32(b[16:]),
+			EndByte:    binary.LittleEndian.Uint32(b[20:]),
+		}
+	}
+	return ranges, nil
+}
+
+// writeRanges stores ranges as a TSRange array at address, which must have
+// room for len(ranges)*rangeSize bytes.
+func (ts *TreeSitter) writeRanges(address uint32, ranges []Range) error {
+	buf := make([]byte, 0, len(ranges)*rangeSize)
+	for _, r := range ranges {
+		buf = binary.LittleEndian.AppendUint32(buf, r.StartPoint.Row)
+		buf = binary.LittleEndian.AppendUint32(buf, r.StartPoint.Column)
+		buf = binary.LittleEndian.AppendUint32(buf, r.EndPoint.Row)
+		buf = binary.LittleEndian.AppendUint32(buf, r.EndPoint.Column)
+		buf = binary.LittleEndian.AppendUint32(buf, r.StartByte)
+		buf = binary.LittleEndian.AppendUint32(buf, r.EndByte)
+	}
+	if !ts.memory.Write(address, buf) {
+		return fmt.Errorf("%w: ranges at %d", ErrMemoryWrite, address)
+	}
+	return nil
+}
+
+// PointToUTF16 converts p, a point whose column counts bytes, to the line and
+// character of a Language Server Protocol position, whose character counts
+// UTF-16 code units. source is the text p refers to. Lines end at "\n" only,
+// as for tree-sitter, so a "\r" before it counts as a character of the line.
+//
+// It is an error for p to lie past the end of its line or inside a
+// multibyte character.
+func PointToUTF16(source []byte, p Point) (line, character uint32, err error) {
+	text, err := sourceLine(source, p.Row)
+	if err != nil {
+		return 0, 0, err
+	}
+	if int(p.Column) > len(text) {
+		return 0, 0, fmt.Errorf("column %d is past the end of line %d", p.Column, p.Row)
+	}
+	for i := 0; i < int(p.Column); {
+		r, size := utf8.DecodeRune(text[i:])
+		if i+size > int(p.Column) {
+			return 0, 0, fmt.Errorf("column %d of line %d is inside a character", p.Column, p.Row)
+		}
+		character += uint32(utf16.RuneLen(r))
+		i += size
+	}
+	return p.Row, character, nil
+}
+
+// UTF16ToByte converts a Language Server Protocol position, whose character
+// counts UTF-16 code units, to a point in source, whose column counts bytes.
+// It is the inverse of PointToUTF16.
+//
+// It is an error for the position to lie past the end of its line or
+// between the two halves of a surrogate pair.
+func UTF16ToByte(source []byte, line, character uint32) (Point, error) {
+	text, err := sourceLine(source, line)
+	if err != nil {
+		return Point{}, err
+	}
+	column := 0
+	for units := uint32(0); units < character; {
+		if column == len(text) {
+			return Point{}, fmt.Errorf("character %d is past the end of line %d", character, line)
+		}
+		r, size := utf8.DecodeRune(text[column:])
+		units += uint32(utf16.RuneLen(r))
+		if units > character {
+			return Point{}, fmt.Errorf("character %d of line %d is inside a surrogate pair", character, line)
+		}
+		column += size
+	}
+	return Point{Row: line, Column: uint32(column)}, nil
+}
+
+// sourceLine returns the text of the line row of source, without its "\n".
+func sourceLine(source []byte, row uint32) ([]byte, error) {
+	for range row {
+		i := bytes.IndexByte(source, '\n')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d is past the end of the source", row)
+		}
+		source = source[i+1:]
+	}
+	if i := bytes.IndexByte(source, '\n'); i >= 0 {
+		source = source[:i]
+	}
+	return source, nil
+}
@@ -0,0 +1,62 @@
+package treesitter
+
+import "testing"
+
+func TestPointToUTF16(t *testing.T) {
+	// "é" is two bytes and one code unit; "😀" is four bytes and two code
+	// units.
+	source := []byte("first\nx é 😀 y\n")
+	tests := []struct {
+		point     Point
+		character uint32
+	}{
+		{Point{Row: 0, Column: 5}, 5},
+		{Point{Row: 1, Column: 0}, 0},
+		{Point{Row: 1, Column: 2}, 2},
+		{Point{Row: 1, Column: 4}, 3},
+		{Point{Row: 1, Column: 5}, 4},
+		{Point{Row: 1, Column: 9}, 6},
+		{Point{Row: 1, Column: 11}, 8},
+	}
+	for _, tt := range tests {
+		line, character, err := PointToUTF16(source, tt.point)
+		if err != nil {
+			t.Errorf("PointToUTF16(%v): %v", tt.point, err)
+			continue
+		}
+		if line != tt.point.Row || character != tt.character {
+			t.Errorf("PointToUTF16(%v) = %d, %d, want %d, %d", tt.point, line, character, tt.point.Row, tt.character)
+		}
+		back, err := UTF16ToByte(source, line, character)
+		if err != nil {
+			t.Errorf("UTF16ToByte(%d, %d): %v", line, character, err)
+			continue
+		}
+		if back != tt.point {
+			t.Errorf("UTF16ToByte(%d, %d) = %v, want %v", line, character, back, tt.point)
+		}
+	}
+}
+
+func TestPointToUTF16Errors(t *testing.T) {
+	source := []byte("x é 😀\n")
+	for _, p := range []Point{
+		{Row: 0, Column: 3},  // inside "é"
+		{Row: 0, Column: 7},  // inside "😀"
+		{Row: 0, Column: 20}, // past the end of the line
+		{Row: 5, Column: 0},  // past the end of the source
+	} {
+		if _, _, err := PointToUTF16(source, p); err == nil {
+			t.Errorf("PointToUTF16(%v) succeeded, want an error", p)
+		}
+	}
+	for _, c := range []struct{ line, character uint32 }{
+		{0, 5},  // between the halves of "😀"
+		{0, 10}, // past the end of the line
+		{3, 0},  // past the end of the source
+	} {
+		if _, err := UTF16ToByte(source, c.line, c.character); err == nil {
+			t.Errorf("UTF16ToByte(%d, %d) succeeded, want an error", c.line, c.character)
+		}
+	}
+}
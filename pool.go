@@ -0,0 +1,265 @@
+package treesitter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ParserPool parses on several independent TreeSitter instances, each with
+// its own WASM memory, so that parses run in parallel rather than queuing on
+// one instance.
+//
+// Grammars are registered with the pool by name and loaded into an instance
+// the first time that instance parses with them.
+type ParserPool struct {
+	engine    *Engine
+	idle      chan *pooledParser
+	instances []*pooledParser
+
+	mu       sync.RWMutex
+	grammars map[string][]byte
+	closed   bool
+}
+
+// pooledParser is one instance of a pool together with its parser and the
+// grammars loaded into it so far.
+type pooledParser struct {
+	ts       *TreeSitter
+	registry *LanguageRegistry
+	parser   *Parser
+}
+
+// NewParserPool starts size instances of the core module, configured by
+// opts.
+func NewParserPool(ctx context.Context, size int, opts ...Option) (*ParserPool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("pool size must be at least 1, got %d", size)
+	}
+	engine, err := NewEngine(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := engine.newParserPool(ctx, size)
+	if err != nil {
+		engine.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// newParserPool starts size instances of e for a pool. If one fails to
+// start, the ones already started are closed.
+func (e *Engine) newParserPool(ctx context.Context, size int) (*ParserPool, error) {
+	pool := &ParserPool{
+		engine:   e,
+		idle:     make(chan *pooledParser, size),
+		grammars: map[string][]byte{},
+	}
+	for range size {
+		ts, err := e.NewInstance(ctx)
+		if err != nil {
+			pool.closeInstances()
+			return nil, err
+		}
+		parser, err := ts.NewParser()
+		if err != nil {
+			ts.Close()
+			pool.closeInstances()
+			return nil, err
+		}
+		p := &pooledParser{ts: ts, registry: ts.NewLanguageRegistry(), parser: parser}
+		pool.instances = append(pool.instances, p)
+		pool.idle <- p
+	}
+	return pool, nil
+}
+
+// closeInstances closes the pool's instances, leaving its engine open.
+func (pool *ParserPool) closeInstances() {
+	for _, p := range pool.instances {
+		p.ts.Close()
+	}
+}
+
+// Size returns the number of instances in the pool.
+func (pool *ParserPool) Size() int {
+	return len(pool.instances)
+}
+
+// Register makes the grammar in wasm available to Parse as name.
+// Registering a name again with the same grammar does nothing; registering
+// it with a different grammar is an error.
+func (pool *ParserPool) Register(name string, wasm []byte) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if existing, ok := pool.grammars[name]; ok {
+		if !bytes.Equal(existing, wasm) {
+			return fmt.Errorf("language %q is already registered with a different grammar", name)
+		}
+		return nil
+	}
+	pool.grammars[name] = bytes.Clone(wasm)
+	return nil
+}
+
+// Parse parses text with the language registered as name on the next idle
+// instance, waiting for one if all are busy. It gives up with ctx.Err() if
+// ctx is done first, and abandons the parse itself as ParseStringContext
+// does.
+//
+// The tree stays bound to the instance that produced it and remains valid
+// until it is deleted or the pool is closed.
+func (pool *ParserPool) Parse(ctx context.Context, name string, text string) (*Tree, error) {
+	pool.mu.RLock()
+	wasm, ok := pool.grammars[name]
+	closed := pool.closed
+	pool.mu.RUnlock()
+	if closed {
+		return nil, fmt.Errorf("%w: parser pool", ErrClosed)
+	}
+	if !ok {
+		return nil, fmt.Errorf("language %q is not registered", name)
+	}
+
+	var p *pooledParser
+	select {
+	case p = <-pool.idle:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { pool.idle <- p }()
+
+	language, ok := p.registry.Get(name)
+	if !ok {
+		if err := p.registry.Register(name, wasm); err != nil {
+			return nil, err
+		}
+		language, _ = p.registry.Get(name)
+	}
+	if p.parser.language != language {
+		if err := p.parser.SetLanguage(language); err != nil {
+			return nil, err
+		}
+	}
+	tree, err := p.parser.ParseStringContext(ctx, text)
+	if err != nil {
+		// Do not let the next caller resume this caller's abandoned parse.
+		p.parser.Reset()
+	}
+	return tree, err
+}
+
+// Close shuts down every instance in the pool. Afterwards Parse, and the
+// methods of the trees it returned, fail with ErrClosed.
+func (pool *ParserPool) Close() error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.closed {
+		return nil
+	}
+	pool.closed = true
+	return pool.engine.Close()
+}
+
+// ParseInput is a text for ParseAll to parse, with the language to parse it
+// with.
+type ParseInput struct {
+	Language *Language
+	Source   []byte
+}
+
+// ParseResult is the outcome of parsing one ParseInput: its tree, or the
+// error that kept it from being parsed.
+type ParseResult struct {
+	Tree *Tree
+	Err  error
+}
+
+// ParseAll parses every input on a ParserPool of concurrency new instances
+// of e and returns the results in the order of inputs. A failure to parse an
+// input is reported in its result and does not stop the others; the error
+// returned is for the batch as a whole, such as ctx being done.
+//
+// Each input's language may come from any instance: its grammar is loaded
+// into the pool's instances as they need it. Each tree stays bound to the
+// instance that produced it, which is closed along with e, or once none of
+// its trees is reachable. The pool's instances that produced no tree are
+// closed before ParseAll returns.
+func (e *Engine) ParseAll(ctx context.Context, inputs []ParseInput, concurrency int) ([]ParseResult, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1, got %d", concurrency)
+	}
+	results := make([]ParseResult, len(inputs))
+	if len(inputs) == 0 {
+		return results, nil
+	}
+	pool, err := e.newParserPool(ctx, min(concurrency, len(inputs)))
+	if err != nil {
+		return nil, err
+	}
+	// Register each distinct grammar with the pool under a name of its own,
+	// since languages from different grammars may share a name.
+	names := map[*Language]string{}
+	for _, input := range inputs {
+		if input.Language == nil || input.Language.wasm == nil || names[input.Language] != "" {
+			continue
+		}
+		name := fmt.Sprintf("input-language-%d", len(names))
+		if err := pool.Register(name, input.Language.wasm); err != nil {
+			pool.closeInstances()
+			return nil, err
+		}
+		names[input.Language] = name
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range pool.Size() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				input := inputs[i]
+				if input.Language == nil {
+					results[i].Err = fmt.Errorf("input %d has no language", i)
+					continue
+				}
+				if input.Language.wasm == nil {
+					results[i].Err = fmt.Errorf("language of input %d was not loaded from a grammar module", i)
+					continue
+				}
+				results[i].Tree, results[i].Err = pool.Parse(ctx, names[input.Language], string(input.Source))
+			}
+		}()
+	}
+	for i := range inputs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	pool.keepInstancesOf(results)
+	return results, ctx.Err()
+}
+
+// keepInstancesOf releases a pool that is done parsing, except for the
+// instances the trees in results are bound to: the others are closed, and
+// the parsers of those kept are deleted, leaving the instances to their
+// trees.
+func (pool *ParserPool) keepInstancesOf(results []ParseResult) {
+	used := map[*TreeSitter]bool{}
+	for _, r := range results {
+		if r.Tree != nil {
+			used[r.Tree.ts] = true
+		}
+	}
+	for _, p := range pool.instances {
+		if used[p.ts] {
+			p.parser.Delete()
+		} else {
+			p.ts.Close()
+		}
+	}
+	pool.instances = nil
+}
@@ -0,0 +1,224 @@
+package treesitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// newTestPool starts a pool of size instances with the test grammar name
+// registered, closed when the test ends.
+func newTestPool(t testing.TB, size int, name string) *ParserPool {
+	t.Helper()
+	pool, err := NewParserPool(context.Background(), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	wasm, err := os.ReadFile("testdata/tree-sitter-" + name + ".wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Register(name, wasm); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestParserPoolClose(t *testing.T) {
+	ctx := context.Background()
+	pool := newTestPool(t, 2, "json")
+	tree, err := pool.Parse(ctx, "json", `[1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Errorf("second Close(): %v", err)
+	}
+	if _, err := pool.Parse(ctx, "json", `[1]`); !errors.Is(err, ErrClosed) {
+		t.Errorf("Parse() after Close: %v, want ErrClosed", err)
+	}
+	if _, err := tree.RootNode(); !errors.Is(err, ErrClosed) {
+		t.Errorf("RootNode() after Close: %v, want ErrClosed", err)
+	}
+}
+
+func TestParserPool(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewParserPool(ctx, 0); err == nil {
+		t.Error("NewParserPool() of size 0 succeeded")
+	}
+	pool := newTestPool(t, 3, "json")
+	if pool.Size() != 3 {
+		t.Errorf("Size() = %d, want 3", pool.Size())
+	}
+	if err := pool.Register("json", emptyModule); err == nil {
+		t.Error("Register() of a different grammar under a used name succeeded")
+	}
+	if _, err := pool.Parse(ctx, "nope", `[]`); err == nil {
+		t.Error("Parse() with an unregistered language succeeded")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 12)
+	for i := range 12 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tree, err := pool.Parse(ctx, "json", fmt.Sprintf(`[%d]`, i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer tree.Delete()
+			root, err := tree.RootNode()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if s, err := root.String(); err != nil || s != "(document (array (number)))" {
+				errs <- fmt.Errorf("tree = %s, %v", s, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := pool.Parse(cancelled, "json", largeJSON(1<<16)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Parse() with a cancelled context: %v, want context.Canceled", err)
+	}
+}
+
+func BenchmarkParserPool(b *testing.B) {
+	text := largeJSON(10000)
+	for _, size := range []int{1, 8} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			pool := newTestPool(b, size, "json")
+			ctx := context.Background()
+			b.SetBytes(int64(len(text)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					tree, err := pool.Parse(ctx, "json", text)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					tree.Delete()
+				}
+			})
+		})
+	}
+}
+
+// openInstances returns the number of e's instances not yet closed.
+func openInstances(e *Engine) int {
+	e.instancesMu.Lock()
+	defer e.instancesMu.Unlock()
+	n := 0
+	for _, w := range e.instances {
+		if ts := w.Value(); ts != nil && !ts.closed {
+			n++
+		}
+	}
+	return n
+}
+
+func TestParseAll(t *testing.T) {
+	ctx := context.Background()
+	e, err := NewEngine(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	ts, err := e.NewInstance(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, golang := loadTestLanguage(t, ts, "json"), loadTestLanguage(t, ts, "go")
+
+	var inputs []ParseInput
+	for i := range 12 {
+		if i%3 == 0 {
+			inputs = append(inputs, ParseInput{Language: golang, Source: fmt.Appendf(nil, "package p%d\n", i)})
+		} else {
+			inputs = append(inputs, ParseInput{Language: json, Source: fmt.Appendf(nil, "[%d]", i)})
+		}
+	}
+	inputs = append(inputs, ParseInput{Source: []byte("[]")})
+	results, err := e.ParseAll(ctx, inputs, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("ParseAll() returned %d results for %d inputs", len(results), len(inputs))
+	}
+	for i, r := range results[:12] {
+		if r.Err != nil {
+			t.Errorf("input %d: %v", i, r.Err)
+			continue
+		}
+		want := "(document (array (number)))"
+		if i%3 == 0 {
+			want = "(source_file (package_clause (package_identifier)))"
+		}
+		if s := nodeString(t, mustRoot(t, r.Tree)); s != want {
+			t.Errorf("input %d: tree = %s, want %s", i, s, want)
+		}
+		if r.Tree.ts == ts {
+			t.Errorf("input %d was parsed by the languages' own instance", i)
+		}
+	}
+	if last := results[12]; last.Err == nil || last.Tree != nil {
+		t.Errorf("input without a language = %+v, want an error", last)
+	}
+
+	if _, err := e.ParseAll(ctx, inputs, 0); err == nil {
+		t.Error("ParseAll() with a concurrency of 0 succeeded")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := e.ParseAll(cancelled, inputs, 4); !errors.Is(err, context.Canceled) {
+		t.Errorf("ParseAll() with a cancelled context: %v, want context.Canceled", err)
+	}
+}
+
+func TestParseAllClosesUnusedInstances(t *testing.T) {
+	ctx := context.Background()
+	e, err := NewEngine(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	ts, err := e.NewInstance(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json := loadTestLanguage(t, ts, "json")
+	// Only one of the four instances can produce a tree.
+	inputs := []ParseInput{{Language: json, Source: []byte("[1]")}, {}, {}, {}}
+	results, err := e.ParseAll(ctx, inputs, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := openInstances(e); got != 2 {
+		t.Errorf("%d instances open after ParseAll(), want the languages' own and the first tree's", got)
+	}
+	if err := results[0].Tree.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	runtime.KeepAlive(ts)
+}
@@ -0,0 +1,187 @@
+package treesitter
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// TSQueryPredicateStepType values, as written by
+// ts_query_predicates_for_pattern.
+const (
+	predicateStepDone    = 0
+	predicateStepCapture = 1
+	predicateStepString  = 2
+)
+
+// predicateStepSize is the size of a TSQueryPredicateStep: its type and a
+// capture or string ID.
+const predicateStepSize = 8
+
+// predicate is a general predicate such as (#eq? @a @b) attached to a query
+// pattern: its operator, without the "#", and its arguments.
+type predicate struct {
+	operator string
+	args     []predicateArg
+	// pattern is the compiled regular expression of a match? or
+	// not-match? predicate.
+	pattern *regexp.Regexp
+}
+
+// predicateArg is a capture or a string argument of a predicate.
+type predicateArg struct {
+	capture   bool
+	captureID uint32
+	value     string
+}
+
+// readPredicates reads and checks the predicates of every pattern in the
+// query. The core module only parses predicates; evaluating them is up to
+// the caller. Operators other than the ones QueryCursor evaluates are kept
+// but ignored.
+func (q *Query) readPredicates() ([][]predicate, error) {
+	ts := q.ts
+	res, err := ts.call("ts_query_pattern_count", uint64(q.pointer))
+	if err != nil {
+		return nil, err
+	}
+	predicates := make([][]predicate, uint32(res[0]))
+	for pattern := range predicates {
+		res, err := ts.call("ts_query_predicates_for_pattern", uint64(q.pointer), uint64(pattern), uint64(ts.transferBuffer))
+		if err != nil {
+			return nil, err
+		}
+		address, count := uint32(res[0]), ts.readTransfer(0)
+		var current []predicateArg
+		for i := range count {
+			stepType, _ := ts.memory.ReadUint32Le(address + i*predicateStepSize)
+			valueID, _ := ts.memory.ReadUint32Le(address + i*predicateStepSize + 4)
+			switch stepType {
+			case predicateStepCapture:
+				current = append(current, predicateArg{capture: true, captureID: valueID})
+			case predicateStepString:
+				value, err := q.stringValueForID(valueID)
+				if err != nil {
+					return nil, err
+				}
+				current = append(current, predicateArg{value: value})
+			case predicateStepDone:
+				p, err := newPredicate(current)
+				if err != nil {
+					return nil, fmt.Errorf("invalid predicate in pattern %d: %w", pattern, err)
+				}
+				predicates[pattern] = append(predicates[pattern], p)
+				current = nil
+			}
+		}
+	}
+	return predicates, nil
+}
+
+// stringValueForID returns the string literal with the given ID in the
+// query.
+func (q *Query) stringValueForID(id uint32) (string, error) {
+	ts := q.ts
+	res, err := ts.call("ts_query_string_value_for_id", uint64(q.pointer), uint64(id), uint64(ts.transferBuffer))
+	if err != nil {
+		return "", err
+	}
+	value, ok := ts.memory.Read(uint32(res[0]), ts.readTransfer(0))
+	if !ok {
+		return "", fmt.Errorf("%w: query string %d out of range", ErrMemoryRead, id)
+	}
+	return string(value), nil
+}
+
+// newPredicate builds a predicate from its steps, the first of which is the
+// operator, and checks the arguments of the operators it knows.
+func newPredicate(steps []predicateArg) (predicate, error) {
+	if len(steps) == 0 || steps[0].capture {
+		return predicate{}, fmt.Errorf("predicate must begin with an operator")
+	}
+	p := predicate{operator: steps[0].value, args: steps[1:]}
+	switch p.operator {
+	case "eq?", "not-eq?", "match?", "not-match?":
+		if len(p.args) != 2 {
+			return predicate{}, fmt.Errorf("#%s takes 2 arguments, got %d", p.operator, len(p.args))
+		}
+		if !p.args[0].capture {
+			return predicate{}, fmt.Errorf("first argument of #%s must be a capture", p.operator)
+		}
+	}
+	switch p.operator {
+	case "match?", "not-match?":
+		if p.args[1].capture {
+			return predicate{}, fmt.Errorf("second argument of #%s must be a string", p.operator)
+		}
+		pattern, err := regexp.Compile(p.args[1].value)
+		if err != nil {
+			return predicate{}, fmt.Errorf("#%s: %w", p.operator, err)
+		}
+		p.pattern = pattern
+	}
+	return p, nil
+}
+
+// evaluates reports whether QueryCursor evaluates the predicate, as opposed
+// to ignoring it.
+func (p *predicate) evaluates() bool {
+	switch p.operator {
+	case "eq?", "not-eq?", "match?", "not-match?":
+		return true
+	}
+	return false
+}
+
+// satisfied reports whether the match satisfies the predicate, reading the
+// text of captured nodes from source. A predicate on a capture the match
+// did not make holds trivially; one on a capture that matched several nodes
+// must hold for each of them.
+func (p *predicate) satisfied(match *QueryMatch, source []byte) (bool, error) {
+	texts, err := captureTexts(match, p.args[0].captureID, source)
+	if err != nil {
+		return false, err
+	}
+	switch p.operator {
+	case "eq?", "not-eq?":
+		want := p.args[1].value
+		if p.args[1].capture {
+			others, err := captureTexts(match, p.args[1].captureID, source)
+			if err != nil {
+				return false, err
+			}
+			if len(others) == 0 {
+				return true, nil
+			}
+			want = others[0]
+		}
+		for _, text := range texts {
+			if (text == want) != (p.operator == "eq?") {
+				return false, nil
+			}
+		}
+	case "match?", "not-match?":
+		for _, text := range texts {
+			if p.pattern.MatchString(text) != (p.operator == "match?") {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// captureTexts returns the source text of each node the match captured
+// under id.
+func captureTexts(match *QueryMatch, id uint32, source []byte) ([]string, error) {
+	var texts []string
+	for _, capture := range match.Captures {
+		if capture.Index != id {
+			continue
+		}
+		text, err := capture.Node.Text(source)
+		if err != nil {
+			return nil, err
+		}
+		texts = append(texts, text)
+	}
+	return texts, nil
+}
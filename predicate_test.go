@@ -0,0 +1,45 @@
+package treesitter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestQueryPredicates(t *testing.T) {
+	p := newTestParser(t, "json")
+	source := `{"a": "a", "b": "c", "ab": 12}`
+	pair := `(pair key: (string (string_content) @k) value: (string (string_content) @v))`
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`(` + pair + ` (#eq? @k @v))`, []string{"@k=a @v=a"}},
+		{`(` + pair + ` (#not-eq? @k @v))`, []string{"@k=b @v=c"}},
+		{`((string_content) @s (#eq? @s "ab"))`, []string{"@s=ab"}},
+		{`((string_content) @s (#not-eq? @s "a"))`, []string{"@s=b", "@s=c", "@s=ab"}},
+		{`((string_content) @s (#match? @s "^a"))`, []string{"@s=a", "@s=a", "@s=ab"}},
+		{`((string_content) @s (#not-match? @s "^a"))`, []string{"@s=b", "@s=c"}},
+		// Predicates other than those four are ignored.
+		{`((number) @n (#any-of? @n "1"))`, []string{"@n=12"}},
+	}
+	for _, tt := range tests {
+		q := newTestQuery(t, p, tt.query)
+		if got := queryMatches(t, p, q, source); !slices.Equal(got, tt.want) {
+			t.Errorf("matches of %s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryPredicateErrors(t *testing.T) {
+	language := loadTestLanguage(t, newTestInstance(t), "json")
+	for _, query := range []string{
+		`((string) @s (#eq? @s))`,
+		`((string) @s (#eq? "x" @s))`,
+		`((string) @s (#match? @s @s))`,
+		`((string) @s (#match? @s "("))`,
+	} {
+		if _, err := language.NewQuery(query); err == nil {
+			t.Errorf("NewQuery(%s) succeeded, want an error", query)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package treesitter
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PrettyString returns the node's S-expression like String, but with each
+// nested node on its own line, indented by two spaces per level and prefixed
+// with the name of the field it is attached through, if any:
+//
+//	(if_statement
+//	  condition: (identifier)
+//	  consequence: (block))
+//
+// As in String, anonymous nodes are left out unless they are missing.
+func (n *Node) PrettyString() (string, error) {
+	return n.sexpr(true, nil)
+}
+
+// ToSExpr returns the node's S-expression like String, but with the text of
+// each named leaf node, read from source, inlined after its type:
+//
+//	(binary_expression left: (identifier "x") right: (int_literal "1"))
+//
+// source must be the text the tree was parsed from. The texts are quoted as
+// Go string literals.
+func (n *Node) ToSExpr(source []byte) (string, error) {
+	if source == nil {
+		source = []byte{}
+	}
+	return n.sexpr(false, source)
+}
+
+// sexpr writes the node's S-expression, on several indented lines if pretty
+// is set, and with the text of named leaves if source is not nil.
+func (n *Node) sexpr(pretty bool, source []byte) (string, error) {
+	cursor, err := n.NewTreeCursor()
+	if err != nil {
+		return "", err
+	}
+	defer cursor.Delete()
+
+	var b strings.Builder
+	// shown records, for each node from n down to the cursor, whether it
+	// was written and so needs a closing parenthesis.
+	var shown []bool
+	depth := 0
+	for {
+		show, err := writeSExprNode(&b, cursor, depth, len(shown) == 0, pretty, source)
+		if err != nil {
+			return "", err
+		}
+		shown = append(shown, show)
+		if show {
+			depth++
+		}
+		moved, err := cursor.GotoFirstChild()
+		if err != nil {
+			return "", err
+		}
+		for !moved {
+			if shown[len(shown)-1] {
+				b.WriteByte(')')
+				depth--
+			}
+			shown = shown[:len(shown)-1]
+			if len(shown) == 0 {
+				return b.String(), nil
+			}
+			if moved, err = cursor.GotoNextSibling(); err != nil {
+				return "", err
+			}
+			if !moved {
+				if _, err := cursor.GotoParent(); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+}
+
+// writeSExprNode writes the opening of the cursor's current node at depth
+// and reports whether it did. Only named and missing nodes are written,
+// except for the node the walk started at, which always is.
+func writeSExprNode(b *strings.Builder, cursor *TreeCursor, depth int, top, pretty bool, source []byte) (bool, error) {
+	node, err := cursor.CurrentNode()
+	if err != nil {
+		return false, err
+	}
+	named, err := node.IsNamed()
+	if err != nil {
+		return false, err
+	}
+	missing, err := node.IsMissing()
+	if err != nil {
+		return false, err
+	}
+	if !named && !missing && !top {
+		return false, nil
+	}
+	nodeType, err := node.Type()
+	if err != nil {
+		return false, err
+	}
+	field, err := cursor.CurrentFieldName()
+	if err != nil {
+		return false, err
+	}
+
+	if !top {
+		if pretty {
+			b.WriteByte('\n')
+			b.WriteString(strings.Repeat("  ", depth))
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	if field != "" && !top {
+		b.WriteString(field)
+		b.WriteString(": ")
+	}
+	b.WriteByte('(')
+	if missing {
+		b.WriteString("MISSING ")
+	}
+	if named {
+		b.WriteString(nodeType)
+	} else {
+		b.WriteString(strconv.Quote(nodeType))
+	}
+	if source != nil && named && !missing {
+		count, err := node.ChildCount()
+		if err != nil {
+			return false, err
+		}
+		if count == 0 {
+			text, err := node.Text(source)
+			if err != nil {
+				return false, err
+			}
+			b.WriteByte(' ')
+			b.WriteString(strconv.Quote(text))
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,31 @@
+package treesitter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToSExpr(t *testing.T) {
+	p := newTestParser(t, "go")
+	source := "package main\n\nvar x = y + 1 // \"c\"\n"
+	_, root := parseTest(t, p, source)
+
+	got, err := root.ToSExpr([]byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `(source_file (package_clause (package_identifier "main")) ` +
+		`(var_declaration (var_spec name: (identifier "x") value: (expression_list ` +
+		`(binary_expression left: (identifier "y") right: (int_literal "1"))))) ` +
+		`(comment "// \"c\""))`
+	if got != want {
+		t.Errorf("ToSExpr() = %s, want %s", got, want)
+	}
+
+	if n, err := ParseSexp(got); err != nil || n.String() != got {
+		t.Errorf("ParseSexp() of the ToSExpr() output = %v, %v", n, err)
+	}
+	if s := nodeString(t, root); strings.Contains(s, `"main"`) {
+		t.Errorf("String() = %s, want it without the token texts", s)
+	}
+}
@@ -0,0 +1,280 @@
+package treesitter
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// queryErrorKinds names the TSQueryError values reported by ts_query_new,
+// indexed by value.
+var queryErrorKinds = []string{
+	1: "syntax",
+	2: "nodeType",
+	3: "field",
+	4: "capture",
+	5: "structure",
+	6: "language",
+}
+
+// QueryError is returned by NewQuery when the query source is invalid.
+type QueryError struct {
+	// Offset is the byte offset in the source at which the error was found.
+	Offset uint32
+	// Kind describes the error: "syntax", "nodeType", "field", "capture",
+	// "structure" or "language".
+	Kind string
+	// source is the query source, quoted in the error message.
+	source string
+}
+
+// Error describes the error and quotes the line of the query source it was
+// found on, with a caret under the offending position:
+//
+//	invalid query: field error at offset 6 (line 1, column 7):
+//	(pair nokey: (string))
+//	      ^
+func (e *QueryError) Error() string {
+	msg := fmt.Sprintf("invalid query: %s error at offset %d", e.Kind, e.Offset)
+	if int(e.Offset) > len(e.source) {
+		return msg
+	}
+	before := e.source[:e.Offset]
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	lineEnd := len(e.source)
+	if i := strings.IndexByte(e.source[e.Offset:], '\n'); i >= 0 {
+		lineEnd = int(e.Offset) + i
+	}
+	line := strings.TrimSuffix(e.source[lineStart:lineEnd], "\r")
+	// The caret is indented with the line's own tabs so that it lines up
+	// however they are displayed.
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, e.source[lineStart:e.Offset])
+	row := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(e.source[lineStart:e.Offset]) + 1
+	return fmt.Sprintf("%s (line %d, column %d):\n%s\n%s^", msg, row, column, line, indent)
+}
+
+// Query is a compiled tree-sitter query, a set of S-expression patterns to
+// match against syntax trees.
+//
+// A query that is never deleted is freed once the garbage collector finds it
+// unreachable, but Delete releases the memory deterministically and is
+// preferred.
+type Query struct {
+	ts       *TreeSitter
+	pointer  uint32
+	language *Language
+	// predicates holds the predicates of each pattern, by pattern index.
+	predicates [][]predicate
+}
+
+// NewQuery compiles source, written in tree-sitter's query language, for the
+// language. An invalid query is reported as a *QueryError.
+//
+// The #eq?, #not-eq?, #match? and #not-match? predicates are checked here
+// and enforced by QueryCursor; #match? takes a Go regular expression. Other
+// predicates are accepted and ignored.
+func (l *Language) NewQuery(source string) (*Query, error) {
+	ts := l.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.closed {
+		return nil, fmt.Errorf("%w: cannot create a query", ErrClosed)
+	}
+	if !ts.hasFunction("ts_query_new") {
+		return nil, fmt.Errorf("%w: queries need ts_query_new", ErrUnsupported)
+	}
+
+	sourcePointer, err := ts.allocateString(source)
+	if err != nil {
+		return nil, err
+	}
+	defer ts.free(sourcePointer)
+	// The error offset and type are uint32 out-parameters, written to the
+	// first two slots of the transfer buffer rather than to memory allocated
+	// for the call.
+	res, err := ts.call("ts_query_new", uint64(l.pointer), uint64(sourcePointer), uint64(len(source)),
+		uint64(ts.transferBuffer), uint64(ts.transferBuffer+4))
+	if err != nil {
+		return nil, err
+	}
+	pointer := uint32(res[0])
+	if pointer == 0 {
+		offset, errorType := ts.readTransfer(0), ts.readTransfer(1)
+		kind := fmt.Sprintf("unknown (%d)", errorType)
+		if int(errorType) < len(queryErrorKinds) && queryErrorKinds[errorType] != "" {
+			kind = queryErrorKinds[errorType]
+		}
+		return nil, &QueryError{Offset: offset, Kind: kind, source: source}
+	}
+	q := &Query{ts: ts, pointer: pointer, language: l}
+	if q.predicates, err = q.readPredicates(); err != nil {
+		ts.call("ts_query_delete", uint64(pointer))
+		return nil, err
+	}
+	runtime.SetFinalizer(q, (*Query).release)
+	return q, nil
+}
+
+// release frees a query the garbage collector found unreachable.
+func (q *Query) release() {
+	q.ts.scheduleRelease("ts_query_delete", q.pointer)
+}
+
+// PatternCount returns the number of patterns in the query.
+func (q *Query) PatternCount() (uint32, error) {
+	q.ts.mu.Lock()
+	defer q.ts.mu.Unlock()
+	return q.callCount("ts_query_pattern_count")
+}
+
+// CaptureCount returns the number of distinct capture names in the query.
+func (q *Query) CaptureCount() (uint32, error) {
+	q.ts.mu.Lock()
+	defer q.ts.mu.Unlock()
+	return q.callCount("ts_query_capture_count")
+}
+
+// StringCount returns the number of distinct string literals in the query,
+// such as the arguments of its predicates.
+func (q *Query) StringCount() (uint32, error) {
+	q.ts.mu.Lock()
+	defer q.ts.mu.Unlock()
+	return q.callCount("ts_query_string_count")
+}
+
+// StartByteForPattern returns the byte offset in the query source at which
+// the pattern with the given index begins.
+func (q *Query) StartByteForPattern(index uint32) (uint32, error) {
+	ts := q.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	count, err := q.callCount("ts_query_pattern_count")
+	if err != nil {
+		return 0, err
+	}
+	if index >= count {
+		return 0, fmt.Errorf("pattern index %d out of range", index)
+	}
+	res, err := ts.call("ts_query_start_byte_for_pattern", uint64(q.pointer), uint64(index))
+	if err != nil {
+		return 0, err
+	}
+	return uint32(res[0]), nil
+}
+
+// callCount calls a ts_query_*_count function.
+func (q *Query) callCount(name string) (uint32, error) {
+	if q.pointer == 0 {
+		return 0, fmt.Errorf("%w: query", ErrDeleted)
+	}
+	res, err := q.ts.call(name, uint64(q.pointer))
+	if err != nil {
+		return 0, err
+	}
+	return uint32(res[0]), nil
+}
+
+// CaptureNameForID returns the name of the capture with the given index, as
+// found in QueryCapture.Index, without the leading "@".
+func (q *Query) CaptureNameForID(id uint32) (string, error) {
+	ts := q.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return q.captureNameForID(id)
+}
+
+// captureNameForID implements CaptureNameForID for callers already holding
+// the lock.
+func (q *Query) captureNameForID(id uint32) (string, error) {
+	ts := q.ts
+	count, err := q.callCount("ts_query_capture_count")
+	if err != nil {
+		return "", err
+	}
+	if id >= count {
+		return "", fmt.Errorf("capture index %d out of range", id)
+	}
+	// The name is a string inside the query, not NUL-terminated; its length
+	// is written to the first slot of the transfer buffer.
+	res, err := ts.call("ts_query_capture_name_for_id", uint64(q.pointer), uint64(id), uint64(ts.transferBuffer))
+	if err != nil {
+		return "", err
+	}
+	name, ok := ts.memory.Read(uint32(res[0]), ts.readTransfer(0))
+	if !ok {
+		return "", fmt.Errorf("%w: capture name for %d out of range", ErrMemoryRead, id)
+	}
+	return string(name), nil
+}
+
+// DisablePattern stops the pattern with the given index from matching in
+// later runs of the query. This cannot be undone: compile the query again to
+// get the pattern back.
+func (q *Query) DisablePattern(index uint32) error {
+	ts := q.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if q.pointer == 0 {
+		return fmt.Errorf("%w: query", ErrDeleted)
+	}
+	if index >= uint32(len(q.predicates)) {
+		return fmt.Errorf("pattern index %d out of range", index)
+	}
+	_, err := ts.call("ts_query_disable_pattern", uint64(q.pointer), uint64(index))
+	return err
+}
+
+// DisableCapture stops later runs of the query from capturing nodes under
+// name, given without the leading "@"; patterns that use it still match. This
+// cannot be undone: compile the query again to get the capture back.
+func (q *Query) DisableCapture(name string) error {
+	ts := q.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	count, err := q.callCount("ts_query_capture_count")
+	if err != nil {
+		return err
+	}
+	found := false
+	for id := range count {
+		captureName, err := q.captureNameForID(id)
+		if err != nil {
+			return err
+		}
+		if captureName == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("query has no capture named %q", name)
+	}
+	pointer, err := ts.allocateString(name)
+	if err != nil {
+		return err
+	}
+	defer ts.free(pointer)
+	_, err = ts.call("ts_query_disable_capture", uint64(q.pointer), uint64(pointer), uint64(len(name)))
+	return err
+}
+
+// Delete frees the query. Deleting a query again does nothing.
+func (q *Query) Delete() error {
+	q.ts.mu.Lock()
+	defer q.ts.mu.Unlock()
+	if q.pointer == 0 {
+		return nil
+	}
+	runtime.SetFinalizer(q, nil)
+	pointer := q.pointer
+	q.pointer = 0
+	_, err := q.ts.call("ts_query_delete", uint64(pointer))
+	return err
+}
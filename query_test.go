@@ -0,0 +1,86 @@
+package treesitter
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestQueryErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *QueryError
+		want string
+	}{
+		{
+			&QueryError{Offset: 6, Kind: "field", source: "(pair nokey: (string))"},
+			"invalid query: field error at offset 6 (line 1, column 7):\n(pair nokey: (string))\n      ^",
+		},
+		{
+			&QueryError{Offset: 13, Kind: "nodeType", source: "(pair)\n\t(é) (nope)\r\n"},
+			"invalid query: nodeType error at offset 13 (line 2, column 6):\n\t(é) (nope)\n\t    ^",
+		},
+		{
+			&QueryError{Offset: 7, Kind: "syntax", source: "(pair"},
+			"invalid query: syntax error at offset 7",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestNewQueryError(t *testing.T) {
+	language := loadTestLanguage(t, newTestInstance(t), "json")
+	tests := []struct {
+		source string
+		kind   string
+		offset uint32
+	}{
+		{`(pair key: (string)`, "syntax", 19},
+		{`(pair nokey: (string))`, "field", 6},
+		{`(object) (nope)`, "nodeType", 10},
+		{`(pair) @a (#eq? @b "x")`, "capture", 17},
+	}
+	for _, tt := range tests {
+		_, err := language.NewQuery(tt.source)
+		var qerr *QueryError
+		if !errors.As(err, &qerr) {
+			t.Errorf("NewQuery(%q): %v, want a *QueryError", tt.source, err)
+			continue
+		}
+		if qerr.Kind != tt.kind || qerr.Offset != tt.offset {
+			t.Errorf("NewQuery(%q): %s error at %d, want %s error at %d", tt.source, qerr.Kind, qerr.Offset, tt.kind, tt.offset)
+		}
+	}
+}
+
+func TestQueryDisable(t *testing.T) {
+	p := newTestParser(t, "json")
+	source := `{"a": 1, "b": true}`
+	q := newTestQuery(t, p, `(pair key: (string) @k value: (_) @v) (true) @t`)
+	if got, want := queryMatches(t, p, q, source), []string{`@k="a" @v=1`, `@k="b" @v=true`, `@t=true`}; !slices.Equal(got, want) {
+		t.Fatalf("matches = %q, want %q", got, want)
+	}
+
+	if err := q.DisableCapture("k"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := queryMatches(t, p, q, source), []string{`@v=1`, `@v=true`, `@t=true`}; !slices.Equal(got, want) {
+		t.Errorf("matches with @k disabled = %q, want %q", got, want)
+	}
+	if err := q.DisablePattern(1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := queryMatches(t, p, q, source), []string{`@v=1`, `@v=true`}; !slices.Equal(got, want) {
+		t.Errorf("matches with pattern 1 disabled = %q, want %q", got, want)
+	}
+
+	if err := q.DisableCapture("nope"); err == nil {
+		t.Error("DisableCapture() of an unknown capture succeeded")
+	}
+	if err := q.DisablePattern(2); err == nil {
+		t.Error("DisablePattern() of an index out of range succeeded")
+	}
+}
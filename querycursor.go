@@ -0,0 +1,437 @@
+package treesitter
+
+import (
+	"fmt"
+	"math"
+)
+
+// QueryCapture is a node captured by a query pattern. Index identifies the
+// capture name within the query, in the order the names first appear in
+// the query source.
+type QueryCapture struct {
+	Index uint32
+	Node  *Node
+}
+
+// QueryMatch is one match of a query pattern: the index of the pattern
+// within the query and the nodes it captured.
+type QueryMatch struct {
+	PatternIndex uint32
+	Captures     []QueryCapture
+}
+
+// CaptureMap returns the match's captured nodes keyed by capture name, as
+// resolved by q, which must be the query that produced the match. If a name
+// captured several nodes, the map holds the last of them.
+func (m *QueryMatch) CaptureMap(q *Query) (map[string]*Node, error) {
+	q.ts.mu.Lock()
+	defer q.ts.mu.Unlock()
+	captures := make(map[string]*Node, len(m.Captures))
+	for _, capture := range m.Captures {
+		name, err := q.captureNameForID(capture.Index)
+		if err != nil {
+			return nil, err
+		}
+		captures[name] = capture.Node
+	}
+	return captures, nil
+}
+
+// Match is a match of a query pattern as returned by Query.Matches: the index
+// of the pattern within the query and the nodes it captured, keyed by
+// capture name without the leading "@".
+type Match struct {
+	PatternIndex uint32
+	Captures     map[string][]*Node
+}
+
+// Matches runs q over node and its descendants and returns every match that
+// satisfies the query's predicates, evaluated against source, the text
+// node's tree was parsed from. source may be nil if the query has no
+// predicates that compare text.
+//
+// It collects the matches of a QueryCursor into one slice; iterate with a
+// QueryCursor instead to stop early or to limit the matches to a range.
+func (q *Query) Matches(node *Node, source []byte) ([]Match, error) {
+	var matches []Match
+	err := q.ForEachMatch(node, source, func(m Match) error {
+		matches = append(matches, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// ForEachMatch is like Matches, but calls fn with each match in turn instead
+// of collecting them, and stops with fn's error once fn returns one. The
+// predicates are evaluated before fn sees a match.
+//
+// The core module still finds every match up front, as for a QueryCursor,
+// but only one Match with its capture map is built at a time.
+func (q *Query) ForEachMatch(node *Node, source []byte, fn func(Match) error) error {
+	names, err := q.captureNames()
+	if err != nil {
+		return err
+	}
+	cursor := NewQueryCursor()
+	if err := cursor.ExecWithSource(q, node, source); err != nil {
+		return err
+	}
+	for {
+		match, ok, err := cursor.NextMatch()
+		if err != nil || !ok {
+			return err
+		}
+		captures := make(map[string][]*Node, len(match.Captures))
+		for _, capture := range match.Captures {
+			name := names[capture.Index]
+			captures[name] = append(captures[name], capture.Node)
+		}
+		if err := fn(Match{PatternIndex: match.PatternIndex, Captures: captures}); err != nil {
+			return err
+		}
+	}
+}
+
+// captureNames returns the query's capture names, indexed by capture index.
+func (q *Query) captureNames() ([]string, error) {
+	q.ts.mu.Lock()
+	defer q.ts.mu.Unlock()
+	count, err := q.callCount("ts_query_capture_count")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, count)
+	for id := range names {
+		if names[id], err = q.captureNameForID(uint32(id)); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// QueryCursor runs a query over a syntax tree and iterates over the matches,
+// or over the individual captures.
+//
+// The core module does not export ts_query_cursor_*; it runs a query to
+// completion with ts_query_matches_wasm or ts_query_captures_wasm and returns
+// every result at once. Exec therefore collects the matches up front and
+// NextMatch hands them out in order; NextCapture does the same with the
+// captures the first time it is called. The cursor owns no WASM memory and
+// needs no Delete.
+//
+// One cursor can run any number of queries, one at a time: each Exec
+// replaces the results of the last, over whatever node and query it is
+// given, and keeps the ranges and match limit set on the cursor. Scanning
+// many files therefore needs only one cursor per goroutine.
+type QueryCursor struct {
+	// startByte, endByte, startPoint and endPoint limit the matches to
+	// the part of the tree they overlap.
+	startByte  uint32
+	endByte    uint32
+	startPoint Point
+	endPoint   Point
+	// matchLimit is the most in-progress matches the query may track.
+	matchLimit uint32
+	// exceeded records whether the last run of the query hit matchLimit.
+	exceeded bool
+
+	query   *Query
+	node    Node
+	source  []byte
+	matches []*QueryMatch
+	next    int
+
+	// captures holds the captures for NextCapture, collected by the first
+	// call after Exec.
+	captures     []matchCapture
+	capturesRead bool
+	nextCapture  int
+}
+
+// matchCapture is a capture returned by NextCapture: the match it belongs to
+// and its index within QueryMatch.Captures.
+type matchCapture struct {
+	match *QueryMatch
+	index uint32
+}
+
+// NewQueryCursor creates a cursor with no query running.
+func NewQueryCursor() *QueryCursor {
+	return &QueryCursor{
+		endByte:    math.MaxUint32,
+		endPoint:   Point{Row: math.MaxUint32, Column: math.MaxUint32},
+		matchLimit: math.MaxUint32,
+	}
+}
+
+// Reset discards the results of the last Exec, so that NextMatch and
+// NextCapture report no more until the next one, and drops the cursor's
+// references to the query, tree and source, letting them be garbage
+// collected. The ranges and match limit are kept.
+func (c *QueryCursor) Reset() {
+	c.exceeded = false
+	c.query = nil
+	c.node = Node{}
+	c.source = nil
+	c.matches = nil
+	c.next = 0
+	c.captures = nil
+	c.capturesRead = false
+	c.nextCapture = 0
+}
+
+// SetByteRange limits later calls to Exec to matches that overlap the bytes
+// from start to end.
+func (c *QueryCursor) SetByteRange(start, end uint32) error {
+	if start > end {
+		return fmt.Errorf("invalid byte range: start %d is after end %d", start, end)
+	}
+	c.startByte, c.endByte = start, end
+	return nil
+}
+
+// SetPointRange limits later calls to Exec to matches that overlap the part
+// of the source from start to end.
+func (c *QueryCursor) SetPointRange(start, end Point) error {
+	if start.Row > end.Row || start.Row == end.Row && start.Column > end.Column {
+		return fmt.Errorf("invalid point range: start %v is after end %v", start, end)
+	}
+	c.startPoint, c.endPoint = start, end
+	return nil
+}
+
+// SetMatchLimit limits later calls to Exec to tracking limit matches in
+// progress at once, bounding the memory a broad query over a large tree can
+// use. Once the limit is reached, the earliest in-progress matches are
+// dropped and DidExceedMatchLimit reports true. By default there is no
+// limit.
+//
+// The core module runs every query of an instance on one internal cursor,
+// which keeps the storage it allocated for in-progress matches. A limit
+// lower than the number of matches an earlier query on the same instance
+// had in progress is therefore only enforced down to that number.
+func (c *QueryCursor) SetMatchLimit(limit uint32) error {
+	if limit == 0 {
+		return fmt.Errorf("invalid match limit: must be at least 1")
+	}
+	c.matchLimit = limit
+	return nil
+}
+
+// MatchLimit returns the limit set with SetMatchLimit, or math.MaxUint32 if
+// there is none.
+func (c *QueryCursor) MatchLimit() uint32 {
+	return c.matchLimit
+}
+
+// DidExceedMatchLimit reports whether the query started by the last Exec,
+// or rerun by NextCapture, dropped matches because it hit the match limit.
+// The results are then incomplete.
+func (c *QueryCursor) DidExceedMatchLimit() bool {
+	return c.exceeded
+}
+
+// Exec runs q over node and its descendants, discarding any matches left
+// over from a previous Exec.
+//
+// Predicates that compare the text of captured nodes need the source the
+// tree was parsed from; use ExecWithSource for queries that have them.
+func (c *QueryCursor) Exec(q *Query, node *Node) error {
+	return c.ExecWithSource(q, node, nil)
+}
+
+// ExecWithSource is like Exec, but lets NextMatch evaluate the query's
+// predicates against source, the text node's tree was parsed from.
+func (c *QueryCursor) ExecWithSource(q *Query, node *Node, source []byte) error {
+	ts := q.ts
+	if node.tree.ts != ts {
+		return fmt.Errorf("node belongs to a different TreeSitter instance")
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if q.pointer == 0 {
+		return fmt.Errorf("%w: query", ErrDeleted)
+	}
+
+	c.Reset()
+	address, count, err := c.run("ts_query_matches_wasm", q, node)
+	if err != nil {
+		return err
+	}
+	if address != 0 {
+		defer ts.free(address)
+	}
+	c.query = q
+	c.node = *node
+	c.source = source
+	c.matches = node.tree.readMatches(address, count, node.shift)
+	return nil
+}
+
+// run calls ts_query_matches_wasm or ts_query_captures_wasm for q over node
+// within the cursor's ranges and match limit and returns the address and
+// length of the result array, which the caller must free.
+func (c *QueryCursor) run(name string, q *Query, node *Node) (uint32, uint32, error) {
+	ts := q.ts
+	if err := node.tree.checkDeleted(); err != nil {
+		return 0, 0, err
+	}
+	node.marshal()
+	// The parameters after the tree are the point range, the byte range,
+	// the match limit, the maximum start depth and the timeout, in code
+	// units. Unlike the point columns, the byte range is not converted from
+	// code units, so it is passed doubled.
+	p := node.positions()
+	start, end := p.unitPoint(c.startPoint), p.unitPoint(c.endPoint)
+	_, err := ts.call(name,
+		uint64(q.pointer), uint64(node.tree.pointer),
+		uint64(start.Row), uint64(start.Column),
+		uint64(end.Row), uint64(end.Column),
+		unitsToBytes(p.unitOffset(c.startByte)), unitsToBytes(p.unitOffset(c.endByte)),
+		uint64(c.matchLimit), math.MaxUint32, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	c.exceeded = ts.readTransfer(2) != 0
+	return ts.readTransfer(1), ts.readTransfer(0), nil
+}
+
+// unitsToBytes converts a code unit offset to the byte offset the core module
+// uses internally, saturating at the largest offset.
+func unitsToBytes(offset uint32) uint64 {
+	return min(2*uint64(offset), math.MaxUint32)
+}
+
+// readMatches decodes the match array written by ts_query_matches_wasm for
+// a node below shift. Each match is its pattern index and capture count
+// followed, for each capture, by the capture index and the marshalled node.
+func (t *Tree) readMatches(address, count uint32, shift *rootOffset) []*QueryMatch {
+	memory := t.ts.memory
+	matches := make([]*QueryMatch, 0, count)
+	for range count {
+		patternIndex, _ := memory.ReadUint32Le(address)
+		captureCount, _ := memory.ReadUint32Le(address + 4)
+		address += 8
+		match := &QueryMatch{PatternIndex: patternIndex, Captures: make([]QueryCapture, captureCount)}
+		for i := range match.Captures {
+			index, _ := memory.ReadUint32Le(address)
+			match.Captures[i] = QueryCapture{Index: index, Node: t.readNodeAt(address+4, shift)}
+			address += 24
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// NextMatch returns the next match of the query started by Exec that
+// satisfies the query's predicates. It reports false once there are no more.
+func (c *QueryCursor) NextMatch() (*QueryMatch, bool, error) {
+	for c.next < len(c.matches) {
+		match := c.matches[c.next]
+		c.next++
+		ok, err := c.satisfiesPredicates(match)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			return match, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// satisfiesPredicates reports whether the match satisfies every predicate of
+// its pattern.
+func (c *QueryCursor) satisfiesPredicates(match *QueryMatch) (bool, error) {
+	for _, p := range c.query.predicates[match.PatternIndex] {
+		if !p.evaluates() {
+			continue
+		}
+		if c.source == nil {
+			return false, fmt.Errorf("#%s needs the source text: use ExecWithSource", p.operator)
+		}
+		ok, err := p.satisfied(match, c.source)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// NextCapture returns the next capture of the query started by Exec, in
+// order of position in the source, rather than grouped by match as with
+// NextMatch. It returns the match the capture belongs to and the index of
+// the capture within match.Captures, and reports false once there are no
+// more. Captures of matches that fail the query's predicates are skipped.
+//
+// This is the order a syntax highlighter wants. NextMatch and NextCapture
+// iterate independently; a cursor is normally used with only one of them.
+func (c *QueryCursor) NextCapture() (*QueryMatch, uint32, bool, error) {
+	if c.query == nil {
+		return nil, 0, false, nil
+	}
+	if !c.capturesRead {
+		if err := c.readCaptures(); err != nil {
+			return nil, 0, false, err
+		}
+	}
+	for c.nextCapture < len(c.captures) {
+		capture := c.captures[c.nextCapture]
+		c.nextCapture++
+		ok, err := c.satisfiesPredicates(capture.match)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if ok {
+			return capture.match, capture.index, true, nil
+		}
+	}
+	return nil, 0, false, nil
+}
+
+// readCaptures runs the query started by Exec again with
+// ts_query_captures_wasm and collects the captures for NextCapture.
+func (c *QueryCursor) readCaptures() error {
+	q, node := c.query, &c.node
+	ts := q.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if q.pointer == 0 {
+		return fmt.Errorf("%w: query", ErrDeleted)
+	}
+	address, count, err := c.run("ts_query_captures_wasm", q, node)
+	if err != nil {
+		return err
+	}
+	if address != 0 {
+		defer ts.free(address)
+	}
+	c.captures = node.tree.readCaptures(address, count, node.shift)
+	c.capturesRead = true
+	return nil
+}
+
+// readCaptures decodes the capture array written by ts_query_captures_wasm.
+// Each capture is laid out like a match in readMatches, with the index of the
+// capture within the match between the capture count and the captures.
+func (t *Tree) readCaptures(address, count uint32, shift *rootOffset) []matchCapture {
+	memory := t.ts.memory
+	captures := make([]matchCapture, 0, count)
+	for range count {
+		patternIndex, _ := memory.ReadUint32Le(address)
+		captureCount, _ := memory.ReadUint32Le(address + 4)
+		captureIndex, _ := memory.ReadUint32Le(address + 8)
+		address += 12
+		match := &QueryMatch{PatternIndex: patternIndex, Captures: make([]QueryCapture, captureCount)}
+		for i := range match.Captures {
+			index, _ := memory.ReadUint32Le(address)
+			match.Captures[i] = QueryCapture{Index: index, Node: t.readNodeAt(address+4, shift)}
+			address += 24
+		}
+		captures = append(captures, matchCapture{match: match, index: captureIndex})
+	}
+	return captures
+}
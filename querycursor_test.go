@@ -0,0 +1,333 @@
+package treesitter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTestQuery compiles source for the language of p.
+func newTestQuery(t testing.TB, p *Parser, source string) *Query {
+	t.Helper()
+	q, err := p.Language().NewQuery(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Delete() })
+	return q
+}
+
+// cursorMatches returns the matches left on c, each written as the captures'
+// names and texts, such as "@k=\"a\" @v=1".
+func cursorMatches(t testing.TB, c *QueryCursor, q *Query, source string) []string {
+	t.Helper()
+	var matches []string
+	for {
+		match, ok, err := c.NextMatch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return matches
+		}
+		var captures []string
+		for _, capture := range match.Captures {
+			name, err := q.CaptureNameForID(capture.Index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, err := capture.Node.Text([]byte(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			captures = append(captures, fmt.Sprintf("@%s=%s", name, text))
+		}
+		matches = append(matches, strings.Join(captures, " "))
+	}
+}
+
+// queryMatches runs q over the tree of source parsed by p and returns its
+// matches as cursorMatches does.
+func queryMatches(t testing.TB, p *Parser, q *Query, source string) []string {
+	t.Helper()
+	_, root := parseTest(t, p, source)
+	c := NewQueryCursor()
+	if err := c.ExecWithSource(q, root, []byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	return cursorMatches(t, c, q, source)
+}
+
+func TestQueryCursor(t *testing.T) {
+	p := newTestParser(t, "json")
+	q := newTestQuery(t, p, `(pair key: (string) @k)`)
+	source := `{"a": 1, "b": {"c": 2}}`
+	_, root := parseTest(t, p, source)
+
+	c := NewQueryCursor()
+	if err := c.Exec(q, root); err != nil {
+		t.Fatal(err)
+	}
+	match, ok, err := c.NextMatch()
+	if err != nil || !ok {
+		t.Fatalf("NextMatch() = %v, %v", ok, err)
+	}
+	if match.PatternIndex != 0 || len(match.Captures) != 1 || match.Captures[0].Index != 0 {
+		t.Errorf("first match = %+v, want one capture @k of pattern 0", match)
+	}
+	if typ := nodeType(t, match.Captures[0].Node); typ != "string" {
+		t.Errorf("captured node type = %q, want string", typ)
+	}
+	if got, want := cursorMatches(t, c, q, source), []string{`@k="b"`, `@k="c"`}; !slices.Equal(got, want) {
+		t.Errorf("remaining matches = %q, want %q", got, want)
+	}
+	if _, ok, err := c.NextMatch(); ok || err != nil {
+		t.Errorf("NextMatch() after the last match = %v, %v, want false", ok, err)
+	}
+}
+
+func TestQueryCursorRanges(t *testing.T) {
+	p := newTestParser(t, "json")
+	q := newTestQuery(t, p, `(number) @n`)
+	source := "[\n  1,\n  2,\n  3\n]"
+	_, root := parseTest(t, p, source)
+
+	c := NewQueryCursor()
+	if err := c.SetByteRange(8, 11); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ExecWithSource(q, root, []byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cursorMatches(t, c, q, source), []string{"@n=2"}; !slices.Equal(got, want) {
+		t.Errorf("matches in bytes 8 to 11 = %q, want %q", got, want)
+	}
+
+	c = NewQueryCursor()
+	if err := c.SetPointRange(Point{Row: 2, Column: 0}, Point{Row: 4, Column: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ExecWithSource(q, root, []byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cursorMatches(t, c, q, source), []string{"@n=2", "@n=3"}; !slices.Equal(got, want) {
+		t.Errorf("matches in rows 2 to 4 = %q, want %q", got, want)
+	}
+
+	// The range is in bytes, even after a two-byte character.
+	source = `["é", 2, 3]`
+	_, root = parseTest(t, p, source)
+	c = NewQueryCursor()
+	if err := c.SetByteRange(7, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ExecWithSource(q, root, []byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cursorMatches(t, c, q, source), []string{"@n=2"}; !slices.Equal(got, want) {
+		t.Errorf("matches in bytes 7 to 8 of %s = %q, want %q", source, got, want)
+	}
+
+	if err := c.SetByteRange(2, 1); err == nil {
+		t.Error("SetByteRange() with start after end succeeded")
+	}
+	if err := c.SetPointRange(Point{Row: 1, Column: 1}, Point{Row: 1, Column: 0}); err == nil {
+		t.Error("SetPointRange() with start after end succeeded")
+	}
+}
+
+func TestQueryCursorNextCapture(t *testing.T) {
+	p := newTestParser(t, "json")
+	// The pair's match completes at the second number, after the match of
+	// the first number, but its key comes first in the source.
+	q := newTestQuery(t, p, `(pair key: (string) @k value: (array (number) (number) @v)) (number) @n`)
+	source := `{"a": [1, 2], "b": [3]}`
+	_, root := parseTest(t, p, source)
+
+	c := NewQueryCursor()
+	if err := c.ExecWithSource(q, root, []byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cursorMatches(t, c, q, source), []string{`@n=1`, `@k="a" @v=2`, `@n=2`, `@n=3`}; !slices.Equal(got, want) {
+		t.Errorf("NextMatch() order = %q, want %q", got, want)
+	}
+
+	if err := c.ExecWithSource(q, root, []byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	var captures []string
+	for {
+		match, index, ok, err := c.NextCapture()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		capture := match.Captures[index]
+		name, err := q.CaptureNameForID(capture.Index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		captures = append(captures, fmt.Sprintf("@%s=%s", name, nodeText(t, capture.Node, source)))
+	}
+	if want := []string{`@k="a"`, `@n=1`, `@v=2`, `@n=2`, `@n=3`}; !slices.Equal(captures, want) {
+		t.Errorf("NextCapture() order = %q, want %q", captures, want)
+	}
+}
+
+func TestQueryCursorMatchLimit(t *testing.T) {
+	p := newTestParser(t, "json")
+	q := newTestQuery(t, p, `(array (number) @a (number) @b)`)
+	_, root := parseTest(t, p, largeJSON(50))
+
+	c := NewQueryCursor()
+	if limit := c.MatchLimit(); limit != math.MaxUint32 {
+		t.Errorf("default MatchLimit() = %d, want math.MaxUint32", limit)
+	}
+	if err := c.SetMatchLimit(0); err == nil {
+		t.Error("SetMatchLimit(0) succeeded")
+	}
+	if err := c.SetMatchLimit(2); err != nil {
+		t.Fatal(err)
+	}
+	if limit := c.MatchLimit(); limit != 2 {
+		t.Errorf("MatchLimit() = %d, want 2", limit)
+	}
+	if err := c.Exec(q, root); err != nil {
+		t.Fatal(err)
+	}
+	if !c.DidExceedMatchLimit() {
+		t.Error("DidExceedMatchLimit() = false with a limit of 2")
+	}
+
+	c = NewQueryCursor()
+	if err := c.Exec(q, root); err != nil {
+		t.Fatal(err)
+	}
+	if c.DidExceedMatchLimit() {
+		t.Error("DidExceedMatchLimit() = true with no limit")
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	p := newTestParser(t, "go")
+	source := `package main
+
+type point struct{ x, y int }
+
+func (p point) norm() int { return p.x*p.x + p.y*p.y }
+
+func main() {}
+
+func helper() {}
+`
+	_, root := parseTest(t, p, source)
+	q := newTestQuery(t, p, `
+		(type_spec name: (type_identifier) @name)
+		(method_declaration name: (field_identifier) @name)
+		((function_declaration name: (identifier) @name) (#not-eq? @name "main"))`)
+
+	matches, err := q.Matches(root, []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range matches {
+		names := m.Captures["name"]
+		if len(names) != 1 || len(m.Captures) != 1 {
+			t.Fatalf("match of pattern %d captured %v, want one @name", m.PatternIndex, m.Captures)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", m.PatternIndex, nodeText(t, names[0], source)))
+	}
+	if want := []string{"0:point", "1:norm", "2:helper"}; !slices.Equal(got, want) {
+		t.Errorf("Matches() = %q, want %q", got, want)
+	}
+}
+
+func TestQueryCursorReuse(t *testing.T) {
+	p := newTestParser(t, "json")
+	source := `{"a": [1, 2], "b": {"c": 3}, "d": "e"}`
+	_, root := parseTest(t, p, source)
+	object := child(t, root, 0)
+	numbers := newTestQuery(t, p, `(number) @n`)
+	contents := newTestQuery(t, p, `(string (string_content) @s)`)
+
+	c := NewQueryCursor()
+	live := p.ts.MemStats().LiveAllocations
+	for _, tt := range []struct {
+		node *Node
+		q    *Query
+		want []string
+	}{
+		{child(t, object, 1), numbers, []string{"@n=1", "@n=2"}},
+		{child(t, object, 3), numbers, []string{"@n=3"}},
+		{child(t, object, 5), contents, []string{"@s=d", "@s=e"}},
+	} {
+		if err := c.ExecWithSource(tt.q, tt.node, []byte(source)); err != nil {
+			t.Fatal(err)
+		}
+		if got := cursorMatches(t, c, tt.q, source); !slices.Equal(got, tt.want) {
+			t.Errorf("matches in %s = %q, want %q", nodeText(t, tt.node, source), got, tt.want)
+		}
+	}
+	if got := p.ts.MemStats().LiveAllocations; got != live {
+		t.Errorf("LiveAllocations after reusing the cursor = %d, want %d", got, live)
+	}
+
+	// Reset drops the results of an Exec not yet read.
+	if err := c.ExecWithSource(numbers, root, []byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	c.Reset()
+	if _, ok, err := c.NextMatch(); ok || err != nil {
+		t.Errorf("NextMatch() after Reset = %v, %v, want no match", ok, err)
+	}
+	if err := c.ExecWithSource(numbers, root, []byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if got := cursorMatches(t, c, numbers, source); len(got) != 3 {
+		t.Errorf("matches after Reset and Exec = %q, want 3", got)
+	}
+}
+
+func TestQueryForEachMatch(t *testing.T) {
+	p := newTestParser(t, "json")
+	source := `{"a": 1, "b": 2, "c": [3, 4], "skip": 5}`
+	_, root := parseTest(t, p, source)
+	q := newTestQuery(t, p, `((pair key: (string (string_content) @k)) (#not-eq? @k "skip"))`)
+
+	matches, err := q.Matches(root, []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	if err := q.ForEachMatch(root, []byte(source), func(m Match) error {
+		if k := nodeText(t, m.Captures["k"][0], source); k == "skip" {
+			t.Errorf("ForEachMatch() passed a match its predicate rejects: %s", k)
+		}
+		count++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || len(matches) != count {
+		t.Errorf("ForEachMatch() found %d matches and Matches() %d, want 3", count, len(matches))
+	}
+
+	errStop := errors.New("stop")
+	count = 0
+	err = q.ForEachMatch(root, []byte(source), func(Match) error {
+		count++
+		if count == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) || count != 2 {
+		t.Errorf("ForEachMatch() stopped after %d matches with %v, want 2 and the callback's error", count, err)
+	}
+}
@@ -0,0 +1,138 @@
+package treesitter
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LanguageRegistry loads grammars into a TreeSitter instance under names of
+// the caller's choosing, such as "json" or a file extension, and hands out
+// the loaded languages by name.
+//
+// Each distinct grammar is linked into the instance only once: registering
+// the same WebAssembly bytes under several names shares one Language.
+type LanguageRegistry struct {
+	ts *TreeSitter
+
+	mu        sync.RWMutex
+	languages map[string]*Language
+	byContent map[[sha256.Size]byte]*Language
+	contents  map[string][sha256.Size]byte
+}
+
+// NewLanguageRegistry creates an empty registry for the instance.
+func (ts *TreeSitter) NewLanguageRegistry() *LanguageRegistry {
+	return &LanguageRegistry{
+		ts:        ts,
+		languages: map[string]*Language{},
+		byContent: map[[sha256.Size]byte]*Language{},
+		contents:  map[string][sha256.Size]byte{},
+	}
+}
+
+// Registry returns the instance's own registry, the one
+// Parser.SetLanguageByName looks languages up in. It is created empty on
+// first use; registries from NewLanguageRegistry are separate from it.
+func (ts *TreeSitter) Registry() *LanguageRegistry {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.registry == nil {
+		ts.registry = ts.NewLanguageRegistry()
+	}
+	return ts.registry
+}
+
+// Register loads the grammar in wasm and makes it available as name.
+// Registering a name again with the same grammar does nothing; registering
+// it with a different grammar is an error.
+func (r *LanguageRegistry) Register(name string, wasm []byte) error {
+	sum := sha256.Sum256(wasm)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.contents[name]; ok {
+		if existing != sum {
+			return fmt.Errorf("language %q is already registered with a different grammar", name)
+		}
+		return nil
+	}
+	language, ok := r.byContent[sum]
+	if !ok {
+		var err error
+		language, err = r.ts.LoadLanguage(wasm)
+		if err != nil {
+			return fmt.Errorf("failed to register language %q: %w", name, err)
+		}
+		r.byContent[sum] = language
+	}
+	r.languages[name] = language
+	r.contents[name] = sum
+	return nil
+}
+
+// RegisterFile is like Register, but reads the grammar from the file at
+// path, which may hold a WebAssembly module or one compressed with Brotli,
+// gzip or zstd.
+func (r *LanguageRegistry) RegisterFile(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to register language %q: %w", name, err)
+	}
+	defer f.Close()
+	return r.RegisterReader(name, f)
+}
+
+// RegisterReader is like Register, but reads the grammar from reader, which
+// may hold a WebAssembly module or one compressed with Brotli, gzip or
+// zstd.
+func (r *LanguageRegistry) RegisterReader(name string, reader io.Reader) error {
+	wasm, err := decompressWasm(reader)
+	if err != nil {
+		return fmt.Errorf("failed to register language %q: %w", name, err)
+	}
+	return r.Register(name, wasm)
+}
+
+// Get returns the language registered as name.
+func (r *LanguageRegistry) Get(name string) (*Language, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	language, ok := r.languages[name]
+	return language, ok
+}
+
+// ParseFile reads the file at path and parses it with the language
+// registered under its extension, without the leading dot: "main.go" is
+// parsed as "go" and "data.json" as "json". It returns the tree together with
+// the language it was parsed as. A file whose extension has no language is
+// reported as ErrNoLanguageForFile.
+func (r *LanguageRegistry) ParseFile(ctx context.Context, path string) (*Tree, *Language, error) {
+	name := strings.TrimPrefix(filepath.Ext(path), ".")
+	language, ok := r.Get(name)
+	if !ok || name == "" {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNoLanguageForFile, path)
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	parser, err := r.ts.NewParser()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer parser.Delete()
+	if err := parser.SetLanguage(language); err != nil {
+		return nil, nil, err
+	}
+	tree, err := parser.ParseStringContext(ctx, string(text))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return tree, language, nil
+}
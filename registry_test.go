@@ -0,0 +1,109 @@
+package treesitter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// newTestRegistry returns a registry of a new instance with the test grammars
+// names registered under their own names.
+func newTestRegistry(t testing.TB, names ...string) *LanguageRegistry {
+	t.Helper()
+	r := newTestInstance(t).NewLanguageRegistry()
+	for _, name := range names {
+		if err := r.RegisterFile(name, "testdata/tree-sitter-"+name+".wasm"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestParseFile(t *testing.T) {
+	r := newTestRegistry(t, "json", "go")
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		file, text, rootType string
+	}{
+		{"main.go", "package main\n", "source_file"},
+		{"data.json", `{"a": 1}`, "document"},
+	} {
+		path := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(path, []byte(tt.text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tree, language, err := r.ParseFile(context.Background(), path)
+		if err != nil {
+			t.Errorf("ParseFile(%s): %v", tt.file, err)
+			continue
+		}
+		if want, _ := r.Get(filepath.Ext(tt.file)[1:]); language != want || tree.Language() != want {
+			t.Errorf("ParseFile(%s) did not use the language registered for its extension", tt.file)
+		}
+		if typ := nodeType(t, mustRoot(t, tree)); typ != tt.rootType {
+			t.Errorf("ParseFile(%s) root type = %s, want %s", tt.file, typ, tt.rootType)
+		}
+	}
+
+	for _, file := range []string{"notes.txt", "Makefile"} {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := r.ParseFile(context.Background(), path); !errors.Is(err, ErrNoLanguageForFile) {
+			t.Errorf("ParseFile(%s): %v, want ErrNoLanguageForFile", file, err)
+		}
+	}
+	if _, _, err := r.ParseFile(context.Background(), filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ParseFile() of a missing file: %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestRegisterFileCompressed(t *testing.T) {
+	wasm, err := os.ReadFile("testdata/tree-sitter-json.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	w := brotli.NewWriter(&b)
+	if _, err := w.Write(wasm); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed := filepath.Join(t.TempDir(), "tree-sitter-json.wasm.br")
+	if err := os.WriteFile(compressed, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRegistry(t)
+	if err := r.RegisterFile("raw", "testdata/tree-sitter-json.wasm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterFile("brotli", compressed); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterReader("reader", bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := r.Get("raw")
+	for _, name := range []string{"brotli", "reader"} {
+		if language, ok := r.Get(name); !ok || language != raw {
+			t.Errorf("language registered as %q is not the one loaded from the raw module", name)
+		}
+	}
+
+	if err := r.RegisterFile("missing", filepath.Join(t.TempDir(), "missing.wasm")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("RegisterFile() of a missing file: %v, want os.ErrNotExist", err)
+	}
+	if err := r.RegisterReader("junk", strings.NewReader("not a module")); err == nil {
+		t.Error("RegisterReader() of junk succeeded")
+	}
+}
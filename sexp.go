@@ -0,0 +1,280 @@
+package treesitter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SexpNode is a node of a syntax tree rebuilt from its S-expression, as
+// written by Node.String, PrettyString or ToSExpr, or found in the test
+// corpus files of tree-sitter grammars.
+type SexpNode struct {
+	// Type is the node's type. Named is false for an anonymous node, which
+	// the S-expression gives as a quoted string.
+	Type  string
+	Named bool
+	// Field is the name of the field the node is attached to its parent
+	// through, or "".
+	Field string
+	// Missing is set for a MISSING node. Type is "" for a bare (MISSING).
+	Missing bool
+	// Text is the node's text, if ToSExpr inlined it.
+	Text string
+	// Char is the character of an UNEXPECTED error as the S-expression
+	// writes it: quoted, as in 'x', '(' or '\n', or as a code point, as in
+	// 128512.
+	Char     string
+	Children []*SexpNode
+}
+
+// ParseSexp parses the S-expression of a syntax tree. Whitespace between
+// tokens is insignificant.
+func ParseSexp(s string) (*SexpNode, error) {
+	p := &sexpParser{s: s}
+	node, err := p.node("")
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(s) {
+		return nil, p.errorf("unexpected text after the root node")
+	}
+	return node, nil
+}
+
+// String returns the node's S-expression in the form Node.String uses.
+func (n *SexpNode) String() string {
+	var b strings.Builder
+	n.write(&b)
+	return b.String()
+}
+
+// write writes the node's S-expression to b.
+func (n *SexpNode) write(b *strings.Builder) {
+	if n.Field != "" {
+		b.WriteString(n.Field)
+		b.WriteString(": ")
+	}
+	b.WriteByte('(')
+	switch {
+	case n.Missing && n.Type == "" && !n.Named:
+		b.WriteString("MISSING")
+	case n.Missing:
+		b.WriteString("MISSING ")
+		fallthrough
+	default:
+		if n.Named {
+			b.WriteString(n.Type)
+		} else {
+			b.WriteString(strconv.Quote(n.Type))
+		}
+	}
+	if n.Char != "" {
+		b.WriteByte(' ')
+		b.WriteString(n.Char)
+	}
+	if n.Text != "" {
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(n.Text))
+	}
+	for _, child := range n.Children {
+		b.WriteByte(' ')
+		child.write(b)
+	}
+	b.WriteByte(')')
+}
+
+// EqualsSexp reports whether the tree has the structure of expected, an
+// S-expression such as a grammar's test corpus gives: the same node types,
+// fields and MISSING nodes, in the same shape. Texts inlined in expected are
+// ignored. If the trees differ, diff describes the first difference.
+func (t *Tree) EqualsSexp(expected string) (equal bool, diff string, err error) {
+	want, err := ParseSexp(expected)
+	if err != nil {
+		return false, "", fmt.Errorf("invalid expected S-expression: %w", err)
+	}
+	root, err := t.RootNode()
+	if err != nil {
+		return false, "", err
+	}
+	s, err := root.String()
+	if err != nil {
+		return false, "", err
+	}
+	got, err := ParseSexp(s)
+	if err != nil {
+		return false, "", err
+	}
+	diff = sexpDiff(want, got, want.Type)
+	return diff == "", diff, nil
+}
+
+// sexpDiff describes the first difference between want and got, found at
+// path, or returns "" if they have the same structure.
+func sexpDiff(want, got *SexpNode, path string) string {
+	if want.Type != got.Type || want.Named != got.Named || want.Missing != got.Missing || want.Field != got.Field || want.Char != got.Char {
+		return fmt.Sprintf("at %s: want %s, got %s", path, sexpHead(want), sexpHead(got))
+	}
+	for i := range min(len(want.Children), len(got.Children)) {
+		if diff := sexpDiff(want.Children[i], got.Children[i], path+"/"+want.Children[i].Type); diff != "" {
+			return diff
+		}
+	}
+	switch {
+	case len(want.Children) > len(got.Children):
+		return fmt.Sprintf("at %s: missing child %s", path, want.Children[len(got.Children)])
+	case len(want.Children) < len(got.Children):
+		return fmt.Sprintf("at %s: unexpected child %s", path, got.Children[len(want.Children)])
+	}
+	return ""
+}
+
+// sexpHead describes a node without its children.
+func sexpHead(n *SexpNode) string {
+	head := *n
+	head.Text = ""
+	head.Children = nil
+	return head.String()
+}
+
+// sexpParser parses an S-expression from s, starting at pos.
+type sexpParser struct {
+	s   string
+	pos int
+}
+
+// errorf reports an error at the current position.
+func (p *sexpParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid S-expression at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skipSpace moves past any whitespace.
+func (p *sexpParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// node parses a parenthesized node attached through field.
+func (p *sexpParser) node(field string) (*SexpNode, error) {
+	if p.skipSpace(); p.pos >= len(p.s) || p.s[p.pos] != '(' {
+		return nil, p.errorf("expected \"(\"")
+	}
+	p.pos++
+	n := &SexpNode{Field: field}
+	p.skipSpace()
+	if word := p.word(); word == "MISSING" {
+		n.Missing = true
+		p.skipSpace()
+	} else {
+		p.pos -= len(word)
+	}
+	if n.Missing && p.pos < len(p.s) && p.s[p.pos] == ')' {
+		// A bare (MISSING), without the type of the missing node.
+	} else if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		typ, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		n.Type = typ
+	} else {
+		n.Type, n.Named = p.word(), true
+		if n.Type == "" {
+			return nil, p.errorf("expected a node type")
+		}
+	}
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, p.errorf("expected \")\"")
+		}
+		switch c := p.s[p.pos]; {
+		case c == ')':
+			p.pos++
+			return n, nil
+		case c == '(':
+			child, err := p.node("")
+			if err != nil {
+				return nil, err
+			}
+			n.Children = append(n.Children, child)
+		case c == '"':
+			text, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			n.Text = text
+		default:
+			name := p.word()
+			if name == "" {
+				return nil, p.errorf("expected a node, a field name or a text")
+			}
+			if charLiteral(name) > 0 || p.pos >= len(p.s) || p.s[p.pos] != ':' {
+				// A bare word, such as the 'x' of (UNEXPECTED 'x').
+				n.Char = name
+				continue
+			}
+			p.pos++
+			child, err := p.node(name)
+			if err != nil {
+				return nil, err
+			}
+			n.Children = append(n.Children, child)
+		}
+	}
+}
+
+// word reads a node type, a field name or the character of an UNEXPECTED
+// error, which may be a quoted character such as '(' or ':'.
+func (p *sexpParser) word() string {
+	start := p.pos
+	if n := charLiteral(p.s[p.pos:]); n > 0 {
+		p.pos += n
+		return p.s[start:p.pos]
+	}
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c == '(' || c == ')' || c == ':' || c == '"' || unicode.IsSpace(rune(c)) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// charLiteral returns the length of the quoted character that s starts with,
+// such as 'x' or '\n', as ts_node_string writes the character of an
+// UNEXPECTED error, or 0 if s does not start with one. A quote is written
+// as three of them.
+func charLiteral(s string) int {
+	switch {
+	case len(s) >= 4 && s[0] == '\'' && s[1] == '\\' && s[3] == '\'':
+		return 4
+	case len(s) >= 3 && s[0] == '\'' && s[2] == '\'':
+		return 3
+	}
+	return 0
+}
+
+// quoted reads a double-quoted string with Go escapes.
+func (p *sexpParser) quoted() (string, error) {
+	start := p.pos
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch p.s[p.pos] {
+		case '\\':
+			p.pos++
+		case '"':
+			p.pos++
+			s, err := strconv.Unquote(p.s[start:p.pos])
+			if err != nil {
+				p.pos = start
+				return "", p.errorf("invalid string: %v", err)
+			}
+			return s, nil
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
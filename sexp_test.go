@@ -0,0 +1,112 @@
+package treesitter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSexp(t *testing.T) {
+	n, err := ParseSexp(`(document (object (pair key: (string (string_content)) (":") value: (number))))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := n.Children[0].Children[0]
+	if pair.Type != "pair" || !pair.Named || len(pair.Children) != 3 {
+		t.Fatalf("pair = %s", pair)
+	}
+	key, colon, value := pair.Children[0], pair.Children[1], pair.Children[2]
+	if key.Field != "key" || key.Type != "string" || value.Field != "value" || value.Type != "number" {
+		t.Errorf("key = %s, value = %s", key, value)
+	}
+	if colon.Type != ":" || colon.Named {
+		t.Errorf("colon = %+v, want an anonymous \":\"", colon)
+	}
+}
+
+func TestParseSexpRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		`(document)`,
+		`(a (b) name: (c (d)) ("+") (MISSING identifier) (MISSING ";") (MISSING))`,
+		`(identifier "x")`,
+		`(ERROR (UNEXPECTED 'x'))`,
+		`(ERROR (UNEXPECTED '('))`,
+		`(ERROR (UNEXPECTED ')'))`,
+		`(ERROR (UNEXPECTED '"'))`,
+		`(ERROR (UNEXPECTED ':'))`,
+		`(ERROR (UNEXPECTED ' '))`,
+		`(ERROR (UNEXPECTED '''))`,
+		`(ERROR (UNEXPECTED '\n'))`,
+		`(ERROR (UNEXPECTED '\0'))`,
+		`(ERROR (UNEXPECTED 128512))`,
+		`(ERROR (UNEXPECTED INVALID))`,
+	} {
+		n, err := ParseSexp(s)
+		if err != nil {
+			t.Errorf("ParseSexp(%s): %v", s, err)
+			continue
+		}
+		if got := n.String(); got != s {
+			t.Errorf("ParseSexp(%s).String() = %s", s, got)
+		}
+	}
+}
+
+func TestParseSexpWhitespace(t *testing.T) {
+	n, err := ParseSexp("(a\n  name:  (b)\n\t(c))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n.String(), "(a name: (b) (c))"; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
+
+func TestParseSexpErrors(t *testing.T) {
+	for _, s := range []string{``, `a`, `(`, `(a`, `(a (b)`, `(a) (b)`, `("unterminated)`, `(a name:)`} {
+		if _, err := ParseSexp(s); err == nil {
+			t.Errorf("ParseSexp(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestEqualsSexp(t *testing.T) {
+	p := newTestParser(t, "json")
+	tree, _ := parseTest(t, p, `{"a": [1, true]}`)
+
+	equal, diff, err := tree.EqualsSexp(`
+		(document
+		  (object
+		    (pair
+		      key: (string (string_content))
+		      value: (array (number) (true)))))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal {
+		t.Errorf("EqualsSexp() of the expected tree reported %s", diff)
+	}
+
+	equal, diff, err = tree.EqualsSexp(`(document (object (pair key: (string (string_content)) value: (array (number) (false)))))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if equal || !strings.Contains(diff, "false") {
+		t.Errorf("EqualsSexp() of a wrong tree = %v, %q, want a difference at (false)", equal, diff)
+	}
+
+	if _, _, err := tree.EqualsSexp(`(document`); err == nil {
+		t.Error("EqualsSexp() of an invalid S-expression succeeded, want an error")
+	}
+}
+
+func TestEqualsSexpUnexpected(t *testing.T) {
+	p := newTestParser(t, "json")
+	tree, root := parseTest(t, p, `[1 @]`)
+	s := nodeString(t, root)
+	if !strings.Contains(s, "UNEXPECTED") {
+		t.Skipf("the parser recovered from the error without an UNEXPECTED node: %s", s)
+	}
+	if equal, diff, err := tree.EqualsSexp(s); err != nil || !equal {
+		t.Errorf("EqualsSexp() of the tree's own S-expression %s = %v, %q, %v", s, equal, diff, err)
+	}
+}
@@ -0,0 +1,301 @@
+package treesitter
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+)
+
+// Tree wraps a TSTree produced by a Parser.
+//
+// A tree that is never deleted is freed once the garbage collector finds it
+// unreachable; nodes and cursors keep their tree reachable. Delete still
+// releases the memory deterministically and is preferred.
+type Tree struct {
+	ts      *TreeSitter
+	pointer uint32
+	// language is the language the tree was parsed with. The core module
+	// does not export ts_tree_language, so the parser records it.
+	language *Language
+	// offsets converts the tree's positions between bytes and the code units
+	// the core module counts in.
+	offsets *textOffsets
+	// inserted holds the ranges of text inserted by Edit since the tree was
+	// parsed, which offsets counts as one code unit per byte until the tree
+	// is reparsed against the new text.
+	inserted []Range
+}
+
+// newTree wraps the TSTree at pointer.
+func (ts *TreeSitter) newTree(pointer uint32, language *Language) *Tree {
+	t := &Tree{ts: ts, pointer: pointer, language: language}
+	runtime.SetFinalizer(t, (*Tree).release)
+	return t
+}
+
+// release frees a tree the garbage collector found unreachable.
+func (t *Tree) release() {
+	t.ts.scheduleRelease("ts_tree_delete", t.pointer)
+}
+
+// Language returns the language the tree was parsed with.
+func (t *Tree) Language() *Language {
+	return t.language
+}
+
+// RootNode returns the root node of the tree.
+func (t *Tree) RootNode() (*Node, error) {
+	t.ts.mu.Lock()
+	defer t.ts.mu.Unlock()
+	return t.rootNode()
+}
+
+// WithRootNode calls fn with the root node of the tree and returns its
+// error.
+//
+// A Node owns no WASM memory and needs no Delete, so the root node cannot
+// leak and RootNode is just as safe; WithRootNode is for callers who prefer
+// to scope the node to a function. The lock is not held while fn runs, so fn
+// may use the node and the tree freely.
+func (t *Tree) WithRootNode(fn func(*Node) error) error {
+	root, err := t.RootNode()
+	if err != nil {
+		return err
+	}
+	return fn(root)
+}
+
+// rootNode implements RootNode for callers already holding the lock.
+func (t *Tree) rootNode() (*Node, error) {
+	if err := t.checkDeleted(); err != nil {
+		return nil, err
+	}
+	if _, err := t.ts.call("ts_tree_root_node_wasm", uint64(t.pointer)); err != nil {
+		return nil, err
+	}
+	return t.readNode(nil), nil
+}
+
+// RootNodeWithOffset returns the root node of the tree with its position
+// shifted by byteOffset and pointOffset, as if the text had been preceded by
+// that much more. Positions obtained from the node and its descendants are
+// shifted the same way, which maps a tree parsed from an embedded document
+// onto the coordinates of the document around it.
+func (t *Tree) RootNodeWithOffset(byteOffset uint32, pointOffset Point) (*Node, error) {
+	ts := t.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := t.checkDeleted(); err != nil {
+		return nil, err
+	}
+	ts.writeTransfer(nodeWords, byteOffset)
+	ts.writePoint(nodeWords+1, pointOffset)
+	if _, err := ts.call("ts_tree_root_node_with_offset_wasm", uint64(t.pointer)); err != nil {
+		return nil, err
+	}
+	return t.readNode(&rootOffset{bytes: byteOffset, point: pointOffset}), nil
+}
+
+// InputEdit describes a change to the source text: the bytes between
+// StartByte and OldEndByte were replaced by the bytes between StartByte and
+// NewEndByte. The points give the same positions as rows and columns.
+type InputEdit struct {
+	StartByte   uint32
+	OldEndByte  uint32
+	NewEndByte  uint32
+	StartPoint  Point
+	OldEndPoint Point
+	NewEndPoint Point
+}
+
+// Edit adjusts the tree to match an edit made to its source text, ahead of
+// an incremental reparse with Parser.ParseStringWithOldTree. Nodes obtained
+// from the tree before the edit keep their old positions; fetch them again
+// afterwards.
+//
+// The core module counts in code units, and the text the edit inserts is not
+// known until the reparse, so it is counted as one code unit per byte until
+// then; the reparse corrects the tree for the text it is given.
+func (t *Tree) Edit(edit InputEdit) error {
+	t.ts.mu.Lock()
+	defer t.ts.mu.Unlock()
+	if err := t.checkDeleted(); err != nil {
+		return err
+	}
+	start, oldEnd := t.offsets.unitPoint(edit.StartPoint), t.offsets.unitPoint(edit.OldEndPoint)
+	units := InputEdit{
+		StartByte:   t.offsets.unitOffset(edit.StartByte),
+		OldEndByte:  t.offsets.unitOffset(edit.OldEndByte),
+		StartPoint:  start,
+		OldEndPoint: oldEnd,
+		NewEndPoint: edit.NewEndPoint,
+	}
+	units.NewEndByte = units.StartByte + (edit.NewEndByte - edit.StartByte)
+	if edit.NewEndPoint.Row == edit.StartPoint.Row {
+		units.NewEndPoint.Column = start.Column + (edit.NewEndPoint.Column - edit.StartPoint.Column)
+	}
+	if err := t.edit(units); err != nil {
+		return err
+	}
+	t.offsets = t.offsets.edit(edit, units)
+	t.inserted = editSpans(t.inserted, edit)
+	return nil
+}
+
+// edit applies edit, given in code units, to the tree in the core module.
+func (t *Tree) edit(edit InputEdit) error {
+	ts := t.ts
+	ts.writePoint(0, edit.StartPoint)
+	ts.writePoint(2, edit.OldEndPoint)
+	ts.writePoint(4, edit.NewEndPoint)
+	ts.writeTransfer(6, edit.StartByte)
+	ts.writeTransfer(7, edit.OldEndByte)
+	ts.writeTransfer(8, edit.NewEndByte)
+	_, err := ts.call("ts_tree_edit_wasm", uint64(t.pointer))
+	return err
+}
+
+// matchText corrects the tree, ahead of a reparse, for the code units the
+// text inserted by Edit takes in next, the offsets of the text it is
+// reparsed against. The spans are corrected from last to first, so that each
+// correction leaves the code units before it, where the earlier spans are,
+// unchanged.
+func (t *Tree) matchText(next *textOffsets) error {
+	for i := len(t.inserted) - 1; i >= 0; i-- {
+		s := t.inserted[i]
+		start, end := next.unitPoint(s.StartPoint), next.unitPoint(s.EndPoint)
+		edit := InputEdit{
+			StartByte:   t.offsets.unitOffset(s.StartByte),
+			OldEndByte:  t.offsets.unitOffset(s.EndByte),
+			StartPoint:  t.offsets.unitPoint(s.StartPoint),
+			OldEndPoint: t.offsets.unitPoint(s.EndPoint),
+			NewEndPoint: end,
+		}
+		edit.NewEndByte = edit.StartByte + (next.unitOffset(s.EndByte) - next.unitOffset(s.StartByte))
+		if end.Row == start.Row {
+			edit.NewEndPoint.Column = edit.StartPoint.Column + (end.Column - start.Column)
+		}
+		if edit.NewEndByte == edit.OldEndByte && edit.NewEndPoint == edit.OldEndPoint {
+			continue
+		}
+		if err := t.edit(edit); err != nil {
+			return err
+		}
+	}
+	t.offsets, t.inserted = next, nil
+	return nil
+}
+
+// Copy returns a new handle to the tree. Copying is cheap: the syntax nodes
+// themselves are shared and reference counted, but the copy is independent,
+// so it can be edited or deleted without affecting t.
+func (t *Tree) Copy() (*Tree, error) {
+	t.ts.mu.Lock()
+	defer t.ts.mu.Unlock()
+	if err := t.checkDeleted(); err != nil {
+		return nil, err
+	}
+	res, err := t.ts.call("ts_tree_copy", uint64(t.pointer))
+	if err != nil {
+		return nil, err
+	}
+	if uint32(res[0]) == 0 {
+		return nil, fmt.Errorf("%w: ts_tree_copy returned a null tree", ErrNullPointer)
+	}
+	c := t.ts.newTree(uint32(res[0]), t.language)
+	c.offsets, c.inserted = t.offsets, slices.Clone(t.inserted)
+	return c, nil
+}
+
+// GetChangedRanges compares t, an edited tree, with other, the tree produced
+// by reparsing it, and returns the ranges whose syntactic structure differs.
+// Re-examining only those ranges is enough to pick up the effect of the edit.
+func (t *Tree) GetChangedRanges(other *Tree) ([]Range, error) {
+	ts := t.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := t.checkDeleted(); err != nil {
+		return nil, err
+	}
+	if err := other.checkDeleted(); err != nil {
+		return nil, err
+	}
+	if _, err := ts.call("ts_tree_get_changed_ranges_wasm", uint64(t.pointer), uint64(other.pointer)); err != nil {
+		return nil, err
+	}
+	count := ts.readTransfer(0)
+	address := ts.readTransfer(1)
+	if address == 0 {
+		return nil, nil
+	}
+	defer ts.free(address)
+	return other.readRanges(address, count)
+}
+
+// IncludedRanges returns the ranges of the text the tree was parsed from, as
+// set with Parser.SetIncludedRanges and normalized by the parser. A tree
+// parsed without included ranges covers the whole text, reported as a single
+// range that starts at 0 and ends past any real offset and point.
+func (t *Tree) IncludedRanges() ([]Range, error) {
+	ts := t.ts
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := t.checkDeleted(); err != nil {
+		return nil, err
+	}
+	if _, err := ts.call("ts_tree_included_ranges_wasm", uint64(t.pointer)); err != nil {
+		return nil, err
+	}
+	count := ts.readTransfer(0)
+	address := ts.readTransfer(1)
+	if address == 0 {
+		return nil, nil
+	}
+	defer ts.free(address)
+	return t.readRanges(address, count)
+}
+
+// readRanges is like TreeSitter.readRanges, but converts the ranges from
+// code units to bytes of the tree's text.
+func (t *Tree) readRanges(address, count uint32) ([]Range, error) {
+	ranges, err := t.ts.readRanges(address, count)
+	if err != nil {
+		return nil, err
+	}
+	p := positions{text: t.offsets}
+	for i, r := range ranges {
+		ranges[i] = p.byteRange(r)
+	}
+	return ranges, nil
+}
+
+// checkDeleted returns ErrDeleted once the tree has been deleted. Every call
+// into the module that takes the tree's pointer, including those of its
+// nodes and cursors, checks it first, since the pointer is 0 by then.
+func (t *Tree) checkDeleted() error {
+	if t.pointer == 0 {
+		return fmt.Errorf("%w: tree", ErrDeleted)
+	}
+	return nil
+}
+
+// Delete frees the tree. Afterwards the tree, and the nodes and cursors
+// obtained from it, return ErrDeleted; a cursor must still be deleted itself.
+// Deleting a tree again does nothing.
+func (t *Tree) Delete() error {
+	t.ts.mu.Lock()
+	defer t.ts.mu.Unlock()
+	return t.delete()
+}
+
+// delete implements Delete for callers already holding the lock.
+func (t *Tree) delete() error {
+	if t.pointer == 0 {
+		return nil
+	}
+	runtime.SetFinalizer(t, nil)
+	pointer := t.pointer
+	t.pointer = 0
+	_, err := t.ts.call("ts_tree_delete", uint64(pointer))
+	return err
+}
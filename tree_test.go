@@ -0,0 +1,280 @@
+package treesitter
+
+import (
+	"errors"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// rangesSize returns the number of bytes ranges cover.
+func rangesSize(ranges []Range) uint32 {
+	var n uint32
+	for _, r := range ranges {
+		n += r.EndByte - r.StartByte
+	}
+	return n
+}
+
+func TestTreeEdit(t *testing.T) {
+	p := newTestParser(t, "json")
+	text := `{"a": 1, "b": [2, 3], "c": 4}`
+	old, _ := parseTest(t, p, text)
+
+	// Replace the 1 with 123.
+	edit := InputEdit{
+		StartByte:   6,
+		OldEndByte:  7,
+		NewEndByte:  9,
+		StartPoint:  Point{Row: 0, Column: 6},
+		OldEndPoint: Point{Row: 0, Column: 7},
+		NewEndPoint: Point{Row: 0, Column: 9},
+	}
+	if err := old.Edit(edit); err != nil {
+		t.Fatal(err)
+	}
+	root, err := old.RootNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end, err := root.EndByte(); err != nil || end != uint32(len(text))+2 {
+		t.Errorf("EndByte() of the edited root = %d, %v, want %d", end, err, len(text)+2)
+	}
+	if changed, err := root.HasChanges(); err != nil || !changed {
+		t.Errorf("HasChanges() of the edited root = %v, %v, want true", changed, err)
+	}
+
+	text = `{"a": 123, "b": [2, 3], "c": 4}`
+	tree, err := p.ParseStringWithOldTree(old, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, _ := parseTest(t, p, text)
+	if got, want := nodeString(t, mustRoot(t, tree)), nodeString(t, mustRoot(t, fresh)); got != want {
+		t.Errorf("incremental parse = %s, want %s", got, want)
+	}
+	ranges, err := old.GetChangedRanges(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := rangesSize(ranges); size >= uint32(len(text)) {
+		t.Errorf("changed ranges %v cover %d of %d bytes, want fewer than a full reparse", ranges, size, len(text))
+	}
+}
+
+func TestGetChangedRanges(t *testing.T) {
+	p := newTestParser(t, "json")
+	old, _ := parseTest(t, p, `{"a": 1, "b": 2}`)
+
+	// Replace the 1 with true.
+	if err := old.Edit(InputEdit{
+		StartByte:   6,
+		OldEndByte:  7,
+		NewEndByte:  10,
+		StartPoint:  Point{Row: 0, Column: 6},
+		OldEndPoint: Point{Row: 0, Column: 7},
+		NewEndPoint: Point{Row: 0, Column: 10},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := p.ParseStringWithOldTree(old, `{"a": true, "b": 2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges, err := old.GetChangedRanges(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Range{StartByte: 6, EndByte: 10, StartPoint: Point{Row: 0, Column: 6}, EndPoint: Point{Row: 0, Column: 10}}
+	if len(ranges) != 1 || ranges[0] != want {
+		t.Errorf("GetChangedRanges() = %v, want [%v]", ranges, want)
+	}
+
+	if ranges, err := tree.GetChangedRanges(tree); err != nil || len(ranges) != 0 {
+		t.Errorf("GetChangedRanges() of a tree against itself = %v, %v, want none", ranges, err)
+	}
+}
+
+func TestTreeDelete(t *testing.T) {
+	p := newTestParser(t, "json")
+	tree, root := parseTest(t, p, `{"a": [1]}`)
+	cursor, err := root.NewTreeCursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(); err != nil {
+		t.Errorf("second Delete(): %v", err)
+	}
+
+	for name, err := range map[string]error{
+		"RootNode":         func() error { _, err := tree.RootNode(); return err }(),
+		"Edit":             tree.Edit(InputEdit{}),
+		"Copy":             func() error { _, err := tree.Copy(); return err }(),
+		"GetChangedRanges": func() error { _, err := tree.GetChangedRanges(tree); return err }(),
+		"Node.Type":        func() error { _, err := root.Type(); return err }(),
+		"Node.String":      func() error { _, err := root.String(); return err }(),
+		"Node.StartByte":   func() error { _, err := root.StartByte(); return err }(),
+		"Node.StartPoint":  func() error { _, err := root.StartPoint(); return err }(),
+		"Node.Edit":        root.Edit(InputEdit{}),
+		"Node.EndByte":     func() error { _, err := root.EndByte(); return err }(),
+		"Node.Child":       func() error { _, err := root.Child(0); return err }(),
+		"Node.DescendantForByteRange": func() error {
+			_, err := root.DescendantForByteRange(0, 1)
+			return err
+		}(),
+		"TreeCursor.GotoFirstChild": func() error { _, err := cursor.GotoFirstChild(); return err }(),
+		"ParseStringWithOldTree": func() error {
+			_, err := p.ParseStringWithOldTree(tree, `{}`)
+			return err
+		}(),
+	} {
+		if !errors.Is(err, ErrDeleted) {
+			t.Errorf("%s after Delete: %v, want ErrDeleted", name, err)
+		}
+	}
+	if err := cursor.Delete(); err != nil {
+		t.Errorf("TreeCursor.Delete() after the tree's Delete: %v", err)
+	}
+}
+
+func TestTreeFinalizer(t *testing.T) {
+	p := newTestParser(t, "json")
+	ts := p.ts
+	baseline := ts.MemStats().LiveAllocations
+	for range 10 {
+		parseTest(t, p, `{"a": [1, 2, 3]}`)
+	}
+	if live := ts.MemStats().LiveAllocations; live != baseline+10 {
+		t.Fatalf("LiveAllocations = %d after 10 parses, want %d", live, baseline+10)
+	}
+
+	// Finalizers run in the background after a collection, and the trees
+	// they release are freed on the next call into the module.
+	for range 100 {
+		runtime.GC()
+		tree, _ := parseTest(t, p, `{}`)
+		tree.Delete()
+		if ts.MemStats().LiveAllocations == baseline {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("LiveAllocations = %d after the trees became unreachable, want %d", ts.MemStats().LiveAllocations, baseline)
+}
+
+func TestRootNodeWithOffset(t *testing.T) {
+	p := newTestParser(t, "json")
+	text := "[1]\n[\"é\"]"
+	tree, _ := parseTest(t, p, text)
+	root, err := tree.RootNodeWithOffset(100, Point{Row: 5, Column: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Range{
+		StartByte:  100,
+		EndByte:    100 + uint32(len(text)),
+		StartPoint: Point{Row: 5, Column: 10},
+		EndPoint:   Point{Row: 6, Column: 6},
+	}
+	if r, err := root.Range(); err != nil || r != want {
+		t.Errorf("Range() of the shifted root = %v, %v, want %v", r, err, want)
+	}
+
+	// A node on a later row is shifted by the rows only.
+	second, err := root.Child(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Range{StartByte: 104, EndByte: 100 + uint32(len(text)), StartPoint: Point{Row: 6, Column: 0}, EndPoint: Point{Row: 6, Column: 6}}
+	if r, err := second.Range(); err != nil || r != want {
+		t.Errorf("Range() of the shifted second array = %v, %v, want %v", r, err, want)
+	}
+	first, err := root.Child(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end, err := first.EndPoint(); err != nil || end != (Point{Row: 5, Column: 13}) {
+		t.Errorf("EndPoint() of the shifted first array = %v, %v, want {5 13}", end, err)
+	}
+}
+
+func TestTreeIncludedRanges(t *testing.T) {
+	p := newTestParser(t, "json")
+	// Two JSON values embedded in lines of other text.
+	text := "// [1]\nx = {\"a\": 1}\ny = [true]\n"
+	ranges := []Range{
+		{StartByte: 11, EndByte: 19, StartPoint: Point{Row: 1, Column: 4}, EndPoint: Point{Row: 1, Column: 12}},
+		{StartByte: 24, EndByte: 30, StartPoint: Point{Row: 2, Column: 4}, EndPoint: Point{Row: 2, Column: 10}},
+	}
+	if err := p.SetIncludedRanges(ranges); err != nil {
+		t.Fatal(err)
+	}
+	tree, root := parseTest(t, p, text)
+	if s := nodeString(t, root); s != "(document (object (pair key: (string (string_content)) value: (number))) (array (true)))" {
+		t.Errorf("tree = %s", s)
+	}
+	got, err := tree.IncludedRanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, ranges) {
+		t.Errorf("IncludedRanges() = %v, want %v", got, ranges)
+	}
+	copied, err := tree.Copy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := copied.IncludedRanges(); err != nil || !slices.Equal(got, ranges) {
+		t.Errorf("IncludedRanges() of a copy = %v, %v, want %v", got, err, ranges)
+	}
+
+	if err := tree.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.IncludedRanges(); !errors.Is(err, ErrDeleted) {
+		t.Errorf("IncludedRanges() of a deleted tree: %v, want ErrDeleted", err)
+	}
+}
+
+func TestWithRootNode(t *testing.T) {
+	p := newTestParser(t, "json")
+	tree, _ := parseTest(t, p, `[1]`)
+	live := p.ts.MemStats().LiveAllocations
+
+	if err := tree.WithRootNode(func(root *Node) error {
+		if typ := nodeType(t, root); typ != "document" {
+			t.Errorf("root node type = %s, want document", typ)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errStop := errors.New("stop")
+	if err := tree.WithRootNode(func(*Node) error { return errStop }); !errors.Is(err, errStop) {
+		t.Errorf("WithRootNode() = %v, want the callback's error", err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the callback's panic did not propagate")
+			}
+		}()
+		tree.WithRootNode(func(*Node) error { panic("callback") })
+	}()
+	// Nothing is left allocated, and the instance is not left locked.
+	if got := p.ts.MemStats().LiveAllocations; got != live {
+		t.Errorf("LiveAllocations after WithRootNode() = %d, want %d", got, live)
+	}
+
+	if err := tree.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	if err := tree.WithRootNode(func(*Node) error { called = true; return nil }); !errors.Is(err, ErrDeleted) || called {
+		t.Errorf("WithRootNode() of a deleted tree = %v, called %v, want ErrDeleted without a call", err, called)
+	}
+}
@@ -0,0 +1,373 @@
+// Package treesitter runs the Tree-sitter parsing library inside a wazero
+// WebAssembly runtime, using the pre-built core module shipped with the
+// web-tree-sitter NPM package. No cgo is required.
+//
+// A TreeSitter value owns one WebAssembly instance and its linear memory.
+// Parsers, trees and nodes obtained from it are only valid while it is open.
+package treesitter
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// compressedWasm is the Brotli-compressed core module produced by `bazel
+// build //:generate`.
+//
+//go:embed lib/treesitter.wasm.br
+var compressedWasm []byte
+
+// requiredFunctions are the exports the wrapper cannot work without.
+var requiredFunctions = []string{
+	"malloc",
+	"free",
+	"ts_init",
+	"ts_parser_new_wasm",
+	"ts_parser_delete",
+	"ts_parser_set_language",
+	"ts_parser_parse_wasm",
+	"ts_tree_delete",
+	"ts_tree_root_node_wasm",
+	"ts_node_to_string_wasm",
+}
+
+// TreeSitter is a running instance of the Tree-sitter core module.
+//
+// A TreeSitter and the parsers, languages, trees, nodes and cursors created
+// from it are safe for concurrent use, but a WASM instance runs one call at a
+// time: calls from different goroutines are serialized, not run in parallel.
+type TreeSitter struct {
+	// mu serializes use of the module, its memory and the transfer buffer.
+	// Exported methods acquire it; unexported ones expect it to be held.
+	mu sync.Mutex
+
+	ctx     context.Context
+	engine  *Engine
+	runtime wazero.Runtime
+	env     api.Module
+	module  api.Module
+	memory  api.Memory
+
+	// closed is set by Close, after which every call into the module fails
+	// with ErrClosed.
+	closed bool
+	// ownsEngine is set for instances created by New, whose engine exists
+	// only to serve them and is closed with them.
+	ownsEngine bool
+	// modules are the modules instantiated for this instance, grammars
+	// included, in the order they were created.
+	modules []api.Module
+
+	// registry is the instance's own LanguageRegistry, created by the first
+	// call to Registry.
+	registry *LanguageRegistry
+
+	// transferBuffer is the address of the core module's TRANSFER_BUFFER,
+	// used to pass nodes, points and other small values in and out. It is
+	// the instance's scratch space: fixed-size arguments and results go
+	// through it instead of memory allocated for each call, so walking a
+	// tree never calls malloc or free.
+	transferBuffer uint32
+
+	// input feeds the parse currently in progress. See parseCallback.
+	input func(buffer, index uint32) uint32
+	// logger receives the log messages of the parse currently in progress.
+	// See logCallback.
+	logger func(LogType, string)
+	// cancellationFlag aborts the parse in progress once nonzero. See
+	// progressCallback.
+	cancellationFlag *uint32
+
+	// stats tracks the allocations reported by MemStats.
+	stats memStats
+
+	// releases are frees queued by finalizers, which run on their own
+	// goroutine and so must not call into the module themselves.
+	releasesMu sync.Mutex
+	releases   []release
+}
+
+// release is a call to a function that frees the memory at pointer.
+type release struct {
+	name    string
+	pointer uint32
+}
+
+// New creates a wazero runtime, links the Tree-sitter core module embedded
+// in the package into it and returns the running instance, configured by
+// opts. Closing the instance closes the runtime too.
+//
+// To create many instances, use an Engine, which compiles the core module
+// only once.
+func New(ctx context.Context, opts ...Option) (*TreeSitter, error) {
+	wasm, err := decompressWasm(bytes.NewReader(compressedWasm))
+	if err != nil {
+		return nil, err
+	}
+	return NewFromWasm(ctx, wasm, opts...)
+}
+
+// NewFromWasm is like New, but runs the given uncompressed core module
+// instead of the embedded one. The module must be a web-tree-sitter build
+// with the same exports.
+func NewFromWasm(ctx context.Context, wasm []byte, opts ...Option) (*TreeSitter, error) {
+	e, err := newEngine(ctx, wasm, opts)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := e.NewInstance(ctx)
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	ts.ownsEngine = true
+	return ts, nil
+}
+
+// Close releases the instance's modules and memory. An instance created by
+// New or NewFromWasm also closes its runtime; one created by
+// Engine.NewInstance leaves the engine open. Afterwards, methods of the
+// instance and of the parsers, trees and other objects created from it fail
+// with ErrClosed. Closing an instance again does nothing.
+//
+// An instance that is never closed is closed once the garbage collector
+// finds it, and everything created from it, unreachable.
+func (ts *TreeSitter) Close() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.closed {
+		return nil
+	}
+	ts.closed = true
+	runtime.SetFinalizer(ts, nil)
+	ts.engine.forget(ts)
+	if ts.ownsEngine {
+		// ts is the engine's only instance, so closing the runtime is all
+		// Engine.Close would do.
+		return ts.engine.runtime.Close(ts.ctx)
+	}
+	var errs []error
+	for i := len(ts.modules) - 1; i >= 0; i-- {
+		errs = append(errs, ts.modules[i].Close(ts.ctx))
+	}
+	ts.modules = nil
+	return errors.Join(errs...)
+}
+
+// HasFunction reports whether the core module exports the function name,
+// such as "ts_query_new", for probing what a core module build supports. A
+// closed instance exports nothing.
+func (ts *TreeSitter) HasFunction(name string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.hasFunction(name)
+}
+
+// hasFunction implements HasFunction for callers already holding the lock.
+func (ts *TreeSitter) hasFunction(name string) bool {
+	if ts.closed {
+		return false
+	}
+	_, ok := ts.module.ExportedFunctionDefinitions()[name]
+	return ok
+}
+
+// ExportedFunctionNames returns the names of the functions the core module
+// exports, in sorted order, or nil once the instance is closed.
+func (ts *TreeSitter) ExportedFunctionNames() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.closed {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(ts.module.ExportedFunctionDefinitions()))
+}
+
+// markClosed marks the instance closed when its engine is, which closes
+// its modules along with the runtime.
+func (ts *TreeSitter) markClosed() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.closed = true
+	ts.modules = nil
+	runtime.SetFinalizer(ts, nil)
+}
+
+// checkTreeSitterFunctions verifies that the module exports everything the
+// wrapper relies on.
+func (ts *TreeSitter) checkTreeSitterFunctions() error {
+	var missing []string
+	for _, name := range requiredFunctions {
+		if !ts.hasFunction(name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: module is missing %v", ErrFunctionNotFound, missing)
+	}
+	return nil
+}
+
+// call invokes an exported function of the core module.
+func (ts *TreeSitter) call(name string, params ...uint64) ([]uint64, error) {
+	return ts.callContext(ts.ctx, name, params...)
+}
+
+// callContext is like call, but makes ctx visible to the host functions the
+// core module calls back into.
+func (ts *TreeSitter) callContext(ctx context.Context, name string, params ...uint64) ([]uint64, error) {
+	if ts.closed {
+		return nil, fmt.Errorf("%w: cannot call %s", ErrClosed, name)
+	}
+	ts.runReleases(ctx)
+	fn := ts.module.ExportedFunction(name)
+	if fn == nil {
+		return nil, fmt.Errorf("%w: %s", ErrFunctionNotFound, name)
+	}
+	res, err := fn.Call(context.WithValue(ctx, instanceKey{}, ts), params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", name, err)
+	}
+	ts.account(name, params, res)
+	return res, nil
+}
+
+// scheduleRelease queues a call to the function name with pointer, to be made
+// before the next call into the module. It is safe to call from a finalizer.
+func (ts *TreeSitter) scheduleRelease(name string, pointer uint32) {
+	ts.releasesMu.Lock()
+	defer ts.releasesMu.Unlock()
+	ts.releases = append(ts.releases, release{name: name, pointer: pointer})
+}
+
+// runReleases makes the calls queued by scheduleRelease. They are best effort:
+// there is no caller left to report a failure to.
+func (ts *TreeSitter) runReleases(ctx context.Context) {
+	ts.releasesMu.Lock()
+	pending := ts.releases
+	ts.releases = nil
+	ts.releasesMu.Unlock()
+	for _, r := range pending {
+		if fn := ts.module.ExportedFunction(r.name); fn != nil {
+			params := []uint64{uint64(r.pointer)}
+			if res, err := fn.Call(ctx, params...); err == nil {
+				ts.account(r.name, params, res)
+			}
+		}
+	}
+}
+
+// malloc allocates size bytes of WASM memory.
+//
+// malloc and free are the core module's own allocator, compiled into it, not
+// imports. The core module reaches them through an internal function
+// pointer rather than through its exports, so the host cannot substitute an
+// allocator of its own, such as a bump allocator for one-shot parses. Trees
+// also outlive the parse that allocated them, so freeing a parse's
+// allocations in bulk when it ends would not be safe anyway.
+func (ts *TreeSitter) malloc(size uint32) (uint32, error) {
+	res, err := ts.call("malloc", uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	ptr := uint32(res[0])
+	if ptr == 0 {
+		return 0, fmt.Errorf("%w: malloc(%d) returned null", ErrNullPointer, size)
+	}
+	return ptr, nil
+}
+
+// free releases memory obtained from malloc.
+func (ts *TreeSitter) free(ptr uint32) error {
+	_, err := ts.call("free", uint64(ptr))
+	return err
+}
+
+// allocateString copies s into WASM memory as a NUL-terminated C string. The
+// caller must free the returned pointer.
+//
+// malloc grows the memory through emscripten_resize_heap when the heap is
+// exhausted, so s may be larger than the memory currently is. It is copied a
+// page at a time so that a failed write reports how far it got.
+func (ts *TreeSitter) allocateString(s string) (uint32, error) {
+	maxPages, _ := ts.memory.Definition().Max()
+	if uint64(len(s)) >= uint64(maxPages)*wasmPageSize {
+		return 0, fmt.Errorf("%w: string of %d bytes does not fit in memory", ErrMemoryWrite, len(s))
+	}
+	size := uint32(len(s))
+	ptr, err := ts.malloc(size + 1)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(ptr)+uint64(size)+1 > uint64(ts.memory.Size()) {
+		ts.free(ptr)
+		return 0, fmt.Errorf("%w: malloc(%d) returned %d past the end of memory", ErrMemoryWrite, size+1, ptr)
+	}
+	for written := uint32(0); written < size; written += wasmPageSize {
+		chunk := s[written:min(written+wasmPageSize, size)]
+		if !ts.memory.WriteString(ptr+written, chunk) {
+			ts.free(ptr)
+			return 0, fmt.Errorf("%w: string at %d after %d of %d bytes", ErrMemoryWrite, ptr, written, size)
+		}
+	}
+	if !ts.memory.WriteByte(ptr+size, 0) {
+		ts.free(ptr)
+		return 0, fmt.Errorf("%w: string at %d", ErrMemoryWrite, ptr)
+	}
+	return ptr, nil
+}
+
+// readCString reads the NUL-terminated string at ptr, which may be at most
+// as long as WithMaxStringLength allows, so that a bad pointer cannot send
+// the scan for the NUL through the whole memory.
+func (ts *TreeSitter) readCString(ptr uint32) (string, error) {
+	return ts.readCStringLimit(ptr, ts.engine.maxStringLength)
+}
+
+// readCStringLimit is like readCString for a string of at most limit bytes.
+func (ts *TreeSitter) readCStringLimit(ptr, limit uint32) (string, error) {
+	if ptr == 0 {
+		return "", fmt.Errorf("%w: string", ErrNullPointer)
+	}
+	size := ts.memory.Size()
+	if ptr >= size {
+		return "", fmt.Errorf("%w: string pointer %d out of range", ErrMemoryRead, ptr)
+	}
+	scan := size - ptr
+	if limit < scan {
+		scan = limit + 1
+	}
+	buf, ok := ts.memory.Read(ptr, scan)
+	if !ok {
+		return "", fmt.Errorf("%w: string pointer %d out of range", ErrMemoryRead, ptr)
+	}
+	n := bytes.IndexByte(buf, 0)
+	if n < 0 {
+		if scan > limit {
+			return "", fmt.Errorf("%w: string at %d is longer than %d bytes", ErrMemoryRead, ptr, limit)
+		}
+		return "", fmt.Errorf("%w: unterminated string at %d", ErrMemoryRead, ptr)
+	}
+	return string(buf[:n]), nil
+}
+
+// readTransfer reads the i-th 32-bit slot of the transfer buffer.
+func (ts *TreeSitter) readTransfer(i uint32) uint32 {
+	v, _ := ts.memory.ReadUint32Le(ts.transferBuffer + 4*i)
+	return v
+}
+
+// writeTransfer stores v in the i-th 32-bit slot of the transfer buffer.
+func (ts *TreeSitter) writeTransfer(i, v uint32) {
+	ts.memory.WriteUint32Le(ts.transferBuffer+4*i, v)
+}
@@ -0,0 +1,279 @@
+package treesitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTestInstance starts an instance with opts, closed when the test ends.
+func newTestInstance(t testing.TB, opts ...Option) *TreeSitter {
+	t.Helper()
+	ts, err := New(context.Background(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ts.Close() })
+	return ts
+}
+
+// loadTestLanguage loads the grammar testdata/tree-sitter-<name>.wasm into
+// ts.
+func loadTestLanguage(t testing.TB, ts *TreeSitter, name string) *Language {
+	t.Helper()
+	wasm, err := os.ReadFile("testdata/tree-sitter-" + name + ".wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	language, err := ts.LoadLanguage(wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return language
+}
+
+// newTestParser starts an instance with the grammar name loaded and returns
+// a parser set to it.
+func newTestParser(t testing.TB, name string) *Parser {
+	t.Helper()
+	ts := newTestInstance(t)
+	parser, err := ts.NewParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.SetLanguage(loadTestLanguage(t, ts, name)); err != nil {
+		t.Fatal(err)
+	}
+	return parser
+}
+
+// parseTest parses text with p and returns the tree and its root node.
+func parseTest(t testing.TB, p *Parser, text string) (*Tree, *Node) {
+	t.Helper()
+	tree, err := p.ParseString(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := tree.RootNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree, root
+}
+
+// mustRoot returns the root node of tree, failing the test on an error.
+func mustRoot(t testing.TB, tree *Tree) *Node {
+	t.Helper()
+	root, err := tree.RootNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// nodeType returns the type of n, failing the test on an error.
+func nodeType(t testing.TB, n *Node) string {
+	t.Helper()
+	typ, err := n.Type()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return typ
+}
+
+// nodeString returns the S-expression of n, failing the test on an error.
+func nodeString(t testing.TB, n *Node) string {
+	t.Helper()
+	s, err := n.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestReadCStringLimit(t *testing.T) {
+	ts := newTestInstance(t, WithMaxStringLength(8))
+
+	short, err := ts.allocateString("short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := ts.readCString(short); err != nil || s != "short" {
+		t.Errorf("readCString() = %q, %v, want %q", s, err, "short")
+	}
+
+	long, err := ts.allocateString(strings.Repeat("x", 9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.readCString(long); !errors.Is(err, ErrMemoryRead) || !strings.Contains(err.Error(), "longer than 8 bytes") {
+		t.Errorf("readCString() of 9 bytes with a limit of 8: %v, want the length error", err)
+	}
+	if s, err := ts.readCStringLimit(long, 9); err != nil || len(s) != 9 {
+		t.Errorf("readCStringLimit(9) = %q, %v", s, err)
+	}
+
+	// A string that runs to the end of memory has no terminator.
+	end := ts.memory.Size() - 4
+	if !ts.memory.Write(end, []byte("abcd")) {
+		t.Fatal("cannot write the end of memory")
+	}
+	if _, err := ts.readCStringLimit(end, math.MaxUint32); !errors.Is(err, ErrMemoryRead) || !strings.Contains(err.Error(), "unterminated") {
+		t.Errorf("readCStringLimit() of an unterminated string: %v, want the unterminated error", err)
+	}
+	if _, err := ts.readCString(0); !errors.Is(err, ErrNullPointer) {
+		t.Errorf("readCString(0): %v, want ErrNullPointer", err)
+	}
+	if _, err := ts.readCString(ts.memory.Size()); !errors.Is(err, ErrMemoryRead) {
+		t.Errorf("readCString() past the end of memory: %v, want ErrMemoryRead", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	p := newTestParser(t, "json")
+	ts := p.ts
+	tree, root := parseTest(t, p, `[1]`)
+	language := loadTestLanguage(t, ts, "json")
+
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Close(); err != nil {
+		t.Errorf("second Close(): %v", err)
+	}
+	if _, err := p.ParseString(`[1]`); !errors.Is(err, ErrClosed) {
+		t.Errorf("ParseString() after Close: %v, want ErrClosed", err)
+	}
+	if _, err := tree.RootNode(); !errors.Is(err, ErrClosed) {
+		t.Errorf("RootNode() after Close: %v, want ErrClosed", err)
+	}
+	if _, err := root.Type(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Type() after Close: %v, want ErrClosed", err)
+	}
+	if _, err := language.NewQuery(`(number)`); !errors.Is(err, ErrClosed) {
+		t.Errorf("NewQuery() after Close: %v, want ErrClosed", err)
+	}
+	if _, err := ts.NewParser(); !errors.Is(err, ErrClosed) {
+		t.Errorf("NewParser() after Close: %v, want ErrClosed", err)
+	}
+	if ts.HasFunction("ts_init") {
+		t.Error("HasFunction() after Close = true")
+	}
+	if names := ts.ExportedFunctionNames(); names != nil {
+		t.Errorf("ExportedFunctionNames() after Close = %v, want nil", names)
+	}
+}
+
+func TestAllocateStringLarge(t *testing.T) {
+	ts := newTestInstance(t, WithMaxMemoryPages(1024))
+	initial := ts.memory.Size()
+	// A string a MiB longer than the whole initial memory.
+	s := strings.Repeat("0123456789abcdef", (int(initial)+1<<20)/16)
+	ptr, err := ts.allocateString(s)
+	if err != nil {
+		t.Fatalf("allocateString() of %d bytes with %d bytes of memory: %v", len(s), initial, err)
+	}
+	if ts.memory.Size() <= initial {
+		t.Errorf("memory did not grow from %d bytes", initial)
+	}
+	if got, err := ts.readCStringLimit(ptr, uint32(len(s))); err != nil || got != s {
+		t.Errorf("readCStringLimit() of the allocated string: %d bytes, %v, want %d", len(got), err, len(s))
+	}
+	ts.free(ptr)
+
+	if _, err := ts.allocateString(strings.Repeat("x", 1024*wasmPageSize)); !errors.Is(err, ErrMemoryWrite) {
+		t.Errorf("allocateString() of the maximum memory size: %v, want ErrMemoryWrite", err)
+	}
+}
+
+func TestHasFunction(t *testing.T) {
+	ts := newTestInstance(t)
+	names := ts.ExportedFunctionNames()
+	if !slices.IsSorted(names) {
+		t.Errorf("ExportedFunctionNames() is not sorted: %v", names)
+	}
+	for _, name := range append([]string{"ts_query_new", "ts_parser_parse_wasm"}, requiredFunctions...) {
+		if !ts.HasFunction(name) {
+			t.Errorf("HasFunction(%q) = false", name)
+		}
+		if !slices.Contains(names, name) {
+			t.Errorf("ExportedFunctionNames() is missing %q", name)
+		}
+	}
+	if ts.HasFunction("ts_no_such_function") {
+		t.Error("HasFunction() of an unknown function = true")
+	}
+}
+
+// BenchmarkSmallParses measures a batch of 100 small parses with the core
+// module's allocator, freeing each tree as it is done, against discarding
+// the whole instance after the batch, the only way to release a batch's
+// memory in bulk since malloc cannot be replaced with a bump allocator.
+func BenchmarkSmallParses(b *testing.B) {
+	wasm, err := os.ReadFile("testdata/tree-sitter-json.wasm")
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	e, err := NewEngine(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer e.Close()
+	newParser := func(ts *TreeSitter) *Parser {
+		language, err := ts.LoadLanguage(wasm)
+		if err != nil {
+			b.Fatal(err)
+		}
+		p, err := ts.NewParser()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := p.SetLanguage(language); err != nil {
+			b.Fatal(err)
+		}
+		return p
+	}
+	parseBatch := func(p *Parser, free bool) {
+		for i := range 100 {
+			tree, err := p.ParseString(fmt.Sprintf(`{"id": %d, "tags": ["a", "b"]}`, i))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if free {
+				tree.Delete()
+			}
+		}
+	}
+
+	b.Run("free", func(b *testing.B) {
+		ts, err := e.NewInstance(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer ts.Close()
+		p := newParser(ts)
+		start := ts.MemStats()
+		for b.Loop() {
+			parseBatch(p, true)
+		}
+		if live := ts.MemStats().LiveAllocations; live != start.LiveAllocations {
+			b.Errorf("LiveAllocations = %d after the batches, want %d", live, start.LiveAllocations)
+		}
+	})
+	b.Run("discard", func(b *testing.B) {
+		for b.Loop() {
+			ts, err := e.NewInstance(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			parseBatch(newParser(ts), false)
+			ts.Close()
+		}
+	})
+}